@@ -1,99 +1,171 @@
 package main
 
 import (
-	"code.google.com/p/rog-go/exp/go/ast"
+	"bufio"
+	"bytes"
 	"code.google.com/p/rog-go/exp/go/token"
 	"code.google.com/p/rog-go/exp/go/types"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"github.com/zhouhua015/goref/refs"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
 var offset = flag.Int("o", -1, "file offset of identifier in stdin")
 var fflag = flag.String("f", "", "Go source filename")
+var sym = flag.String("sym", "",
+	"qualified name of identifier, like \"import/path/pkg.Type.Method\", instead of -f and -o")
+var posFlag = flag.String("pos", "",
+	"position of identifier, as file:line:column, instead of -f and -o")
+var unit = flag.String("unit", unitBytes,
+	"unit of columns in -pos and output, \"bytes\", \"runes\" or \"utf16\"")
 var rflag = flag.Bool("R", false, "recurse into sub-directories of given path")
+var scope = flag.String("scope", refs.ScopePath,
+	"search scope, \"path\" for every file in given path, \"importers\" for packages in given path importing the subject's package")
 var verbose = flag.Bool("v", false, "show matched line")
+var jsonOutput = flag.Bool("json", false,
+	"print the subject and each reference as a JSON object, one per line")
+var modified = flag.Bool("modified", false,
+	"read an archive of modified files from standard input, each file as: name, size in bytes, and contents, separated by newline")
+var mode = flag.String("mode", refs.ModeRefs,
+	"search mode, \"refs\" for references, \"implements\" for implementations of interface or interfaces satisfied by type")
+var dispatch = flag.Bool("dispatch", false,
+	"match interface methods with methods of implementing types, and vice versa")
+var kind = flag.String("kind", "",
+	"comma separated kinds of references to print: decl, write, read, call, key, type, import or pointer")
+var tags = flag.String("tags", "", "comma separated build tags, files are selected by build constraints with them")
+var goos = flag.String("goos", "", "GOOS files are selected for, $GOOS or the running one by default")
+var goarch = flag.String("goarch", "", "GOARCH files are selected for, $GOARCH or the running one by default")
+var allConfigs = flag.Bool("allconfigs", false,
+	"search files selected for every known GOOS and GOARCH, and merge references found")
+var tests = flag.Bool("tests", true, "search _test.go files, both in-package and external tests")
+var importing = flag.Bool("importing", false,
+	"for name of imported package, search every file importing the package, not just the one given")
+var jobs = flag.Int("j", runtime.NumCPU(), "number of packages parsed, and files scanned, concurrently")
+var index = flag.Bool("index", false,
+	"answer from the reference index in -indexdir, and update it by scanning changed files only")
+var indexDir = flag.String("indexdir", refs.DefaultIndexDir(), "directory of reference indexes, for -index")
+var stats = flag.Bool("stats", false, "report numbers of files pruned, parsed and scanned, after references")
+var serveFlag = flag.Bool("serve", false,
+	"run as daemon answering queries on socket, keeping imported packages in memory until they're changed")
+var socket = flag.String("socket", defaultSocket(),
+	"Unix domain socket of daemon, queries are sent to it if daemon is running")
+var lsp = flag.Bool("lsp", false,
+	"run as language server over stdio, answering textDocument/references and textDocument/documentHighlight")
+var noDaemon = flag.Bool("no-daemon", false, "answer query without daemon, even if it's running")
+var to = flag.String("to", "", "new name of identifier, for rename")
+var diff = flag.Bool("diff", false, "print unified diff instead of writing files, for rename")
 var debug = flag.Bool("debug", false, "debug mode")
 var typdebug = flag.Bool("typdebug", false,
 	"turn on type debug mode too, must be used with debug mode")
 
-var Debug bool = false
-var Verbose bool = false
-
-func debugp(f string, a ...interface{}) {
-	if Debug {
-		log.Printf(f, a...)
-	}
-}
-
 func fail(s string, a ...interface{}) {
 	fmt.Fprint(os.Stderr, "goref: "+fmt.Sprintf(s, a...)+"\n")
 	os.Exit(2)
 }
 
-func init() {
-	// take GOPATH, set types.GoPath to it  if it's not empty.
-	p := os.Getenv("GOPATH")
-	if p == "" {
-		return
-	}
-	gopath := strings.Split(p, ":")
-	for i, d := range gopath {
-		gopath[i] = filepath.Join(d, "src")
-	}
-	r := runtime.GOROOT()
-	if r != "" {
-		gopath = append(gopath, r+"/src/pkg")
-	}
-	types.GoPath = gopath
-}
-
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "usage: goref [flags] PATH\n")
+		fmt.Fprintf(os.Stderr, "       goref -scope=importers [flags] [PATH]\n")
+		fmt.Fprintf(os.Stderr, "       goref rename -to NAME [-diff] [flags] PATH\n")
 		flag.PrintDefaults()
 	}
-	flag.Parse()
+	renaming := len(os.Args) > 1 && os.Args[1] == "rename"
+	if renaming {
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 
-	Debug = *debug
-	searchPos := *offset
-	fileName := *fflag
-	recurse := *rflag
 	types.Debug = *debug && *typdebug
-	if flag.NArg() != 1 || searchPos == -1 || fileName == "" {
+	var logger *log.Logger
+	if *debug {
+		logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if *serveFlag {
+		serve(*socket, logger)
+		return
+	}
+	if *lsp {
+		serveLSP(logger)
+		return
+	}
+
+	// importers are looked up in the module, or GOPATH tree, of the
+	// declaring package if PATH is not given
+	importers := *scope == refs.ScopeImporters && flag.NArg() == 0
+	if flag.NArg() != 1 && !importers || *sym == "" && *posFlag == "" && (*offset == -1 || *fflag == "") || renaming && *to == "" {
 		flag.Usage()
 		os.Exit(2)
 	}
-	path := flag.Args()[0]
-	Verbose = *verbose
 
-	// pre-process parameters
-	absFileName, err := filepath.Abs(fileName)
-	if err != nil {
-		fail("cannot get absolute path for file %s, %v", fileName, err)
+	query := refs.Query{
+		FileName: *fflag,
+		Offset:   *offset,
+		Symbol:   *sym,
+		Path:     flag.Arg(0),
+		Recurse:  *rflag,
+		Scope:    *scope,
+		Mode:     *mode,
+		Dispatch: *dispatch,
+		Jobs:     *jobs,
 	}
-	fileName, err = filepath.EvalSymlinks(absFileName)
-	if err != nil {
-		fail("cannot resolve symlinks for file %s, %v", fileName, err)
+	if *index {
+		query.Index = *indexDir
 	}
-
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		fail("cannot get absolute path for %s, %v", path, err)
+	if *kind != "" {
+		query.Kinds = strings.Split(*kind, ",")
 	}
-	path, err = filepath.EvalSymlinks(absPath)
-	if err != nil {
-		fail("cannot resolve symlinks for path %s, %v", path, err)
+	if *tags != "" {
+		query.Tags = strings.Split(*tags, ",")
+	}
+	query.GOOS, query.GOARCH, query.AllConfigs = *goos, *goarch, *allConfigs
+	query.SkipTests = !*tests
+	query.Importing = *importing
+	query.Logger = logger
+	if *modified {
+		var err error
+		query.Overlay, err = parseArchive(os.Stdin)
+		if err != nil {
+			fail("cannot read modified files archive, %v", err)
+		}
+	}
+	if !isUnit(*unit) {
+		fail("unknown column unit %s", *unit)
+	}
+	if *posFlag != "" {
+		var err error
+		query.FileName, query.Offset, err = parsePos(*posFlag, *unit, query.Overlay)
+		if err != nil {
+			fail("%v", err)
+		}
+	}
+
+	if renaming {
+		rename(query, *to, *diff)
+		return
 	}
 
-	filenames, err := getFileNames(recurse, path)
+	var result *refs.Result
+	var err error
+	ok := false
+	if !*noDaemon {
+		result, ok, err = askDaemon(*socket, query)
+	}
+	if !ok {
+		result, err = refs.Find(context.Background(), query)
+	}
 	if err != nil {
-		fail("cannot find any go file in %s, %v", path, err)
+		fail("%v", err)
 	}
 
 	wd, err := os.Getwd()
@@ -101,79 +173,174 @@ func main() {
 		wd = ""
 	}
 
-	context := NewContext(fileName, searchPos, path)
-	context.RefPrinter = func(n ast.Expr) {
-		position := context.WhereIs(n)
-		printRefPosition(wd, position)
+	if *jsonOutput {
+		printSubjectJson(result, query.Overlay, wd)
 	}
-
-	err = context.ParseSubject()
-	if err != nil {
-		fail("parse identifier failed, %s", fmt.Sprintf("%v", err))
-	}
-
-	if position, ok := context.SubjectDeclPos(); ok {
-		printRefPosition(wd, position)
+	for _, ref := range result.References {
+		if *jsonOutput {
+			printRefJson(ref, query.Overlay, wd)
+			continue
+		}
+		printRefPosition(ref.Start, query.Overlay, wd)
 	}
-	err = context.ScanFiles(filenames)
-	if err != nil {
-		fail(err.Error())
+	if *stats {
+		printStats(result.Stats)
 	}
 }
 
-func isGoFile(d os.FileInfo) bool {
-	return strings.HasSuffix(d.Name(), ".go") &&
-		!strings.HasPrefix(d.Name(), ".")
-}
+// parseArchive reads the archive of modified files, each one consists of
+// file name, size of contents in bytes, and the contents, like:
+//
+//	/path/to/file.go
+//	1024
+//	...contents...
+func parseArchive(r io.Reader) (map[string][]byte, error) {
+	overlay := make(map[string][]byte)
+	reader := bufio.NewReader(r)
+	for {
+		name, err := reader.ReadString('\n')
+		if err == io.EOF && name == "" {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name = strings.TrimSpace(name)
 
-func getFileNames(recurse bool, path string) ([]string, error) {
-	fd, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer fd.Close()
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("cannot read size of %s, %v", name, err)
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size of %s, %s", name, line)
+		}
 
-	list, err := fd.Readdir(-1)
-	if err != nil {
-		return nil, err
-	}
-
-	filenames := make([]string, 0)
-	n := 0
-	for i := 0; i < len(list); i++ {
-		d := list[i]
-		absPath := filepath.Join(path, d.Name())
-
-		switch {
-		case d.IsDir() && recurse:
-			fns, err := getFileNames(recurse, absPath)
-			if err != nil {
-				return nil, err
-			}
-
-			filenames = append(filenames, fns...)
-			n += len(fns)
-		case isGoFile(d):
-			filenames = append(filenames, absPath)
-			n++
+		src := make([]byte, size)
+		if _, err = io.ReadFull(reader, src); err != nil {
+			return nil, fmt.Errorf("cannot read contents of %s, %v", name, err)
+		}
+
+		// file names are compared with the ones processed the same way
+		if abs, err := filepath.Abs(name); err == nil {
+			name = abs
 		}
+		if resolved, err := filepath.EvalSymlinks(name); err == nil {
+			name = resolved
+		}
+		overlay[name] = src
 	}
 
-	return filenames[:n], nil
+	return overlay, nil
 }
 
-func printRefPosition(base string, pos token.Position) {
+func printRefPosition(pos token.Position, overlay map[string][]byte, base string) {
 	refPosition := fmt.Sprintf("%s:%d:%d",
 		processFilePath(pos.Filename, base),
 		pos.Line,
-		pos.Column)
-	if Verbose {
-		line := readFileLine(pos)
+		outputColumn(overlay, pos))
+	if *verbose {
+		line, err := readFileLine(overlay, pos)
+		if err != nil {
+			fail("cannot read line of reference, %v", err)
+		}
 		refPosition += fmt.Sprintf("\n%s", line)
 	}
 	fmt.Println(refPosition)
 }
 
+// jsonPosition is a position in source file, of "-json" output
+type jsonPosition struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// jsonRef is a reference, of "-json" output
+type jsonRef struct {
+	Filename string `json:"filename"`
+	RelName  string `json:"relname"`
+	jsonPosition
+	End    jsonPosition `json:"end"`
+	Func   string       `json:"func,omitempty"`
+	Kind   string       `json:"kind,omitempty"`
+	Test   bool         `json:"test,omitempty"`
+	Source string       `json:"source"`
+}
+
+// jsonSubject describes the subject, it's the header of "-json" output
+type jsonSubject struct {
+	Name    string   `json:"name"`
+	Kind    string   `json:"kind"`
+	Package string   `json:"package"`
+	Decl    *jsonRef `json:"decl,omitempty"`
+}
+
+// jsonStats is the trailer of "-json" output, with "-stats"
+type jsonStats struct {
+	Files   int `json:"files"`
+	Pruned  int `json:"pruned"`
+	Parsed  int `json:"parsed"`
+	Scanned int `json:"scanned"`
+}
+
+func newJsonRef(ref refs.Reference, overlay map[string][]byte, base string) *jsonRef {
+	start, end := ref.Start, ref.End
+	source, err := readFileLine(overlay, start)
+	if err != nil {
+		fail("cannot read line of reference, %v", err)
+	}
+	return &jsonRef{
+		Filename:     start.Filename,
+		RelName:      processFilePath(start.Filename, base),
+		jsonPosition: jsonPosition{start.Offset, start.Line, outputColumn(overlay, start)},
+		End:          jsonPosition{end.Offset, end.Line, outputColumn(overlay, end)},
+		Func:         ref.Func,
+		Kind:         ref.Kind,
+		Test:         ref.Test,
+		Source:       source,
+	}
+}
+
+func printRefJson(ref refs.Reference, overlay map[string][]byte, base string) {
+	printJson(newJsonRef(ref, overlay, base))
+}
+
+func printSubjectJson(result *refs.Result, overlay map[string][]byte, base string) {
+	header := struct {
+		Subject jsonSubject `json:"subject"`
+	}{jsonSubject{
+		Name:    result.Name,
+		Kind:    result.Kind,
+		Package: result.Package,
+	}}
+
+	if result.DeclPos.IsValid() {
+		decl := refs.Reference{Start: result.DeclPos, End: result.DeclEnd}
+		header.Subject.Decl = newJsonRef(decl, overlay, base)
+	}
+	printJson(header)
+}
+
+func printStats(stats refs.Stats) {
+	if *jsonOutput {
+		printJson(struct {
+			Stats jsonStats `json:"stats"`
+		}{jsonStats(stats)})
+		return
+	}
+	fmt.Printf("%d files, %d pruned, %d parsed, %d scanned\n",
+		stats.Files, stats.Pruned, stats.Parsed, stats.Scanned)
+}
+
+func printJson(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		fail("cannot encode JSON output, %v", err)
+	}
+	fmt.Println(string(b))
+}
+
 func processFilePath(path string, base string) string {
 	if strings.HasPrefix(path, base) {
 		rel, err := filepath.Rel(base, path)
@@ -186,33 +353,34 @@ func processFilePath(path string, base string) string {
 	return path
 }
 
-func readFileLine(position token.Position) string {
-	src, err := ioutil.ReadFile(position.Filename)
-	if err != nil {
-		return ""
+// outputColumn gives column of position in the unit given by -unit
+func outputColumn(overlay map[string][]byte, position token.Position) int {
+	if *unit == unitBytes {
+		return position.Column
 	}
 
-	var start int
-	for offset := position.Offset; offset >= 0; offset-- {
-		if src[offset] == '\n' {
-			start = offset + 1
-			break
-		}
-	}
-	if start < 0 {
-		return ""
+	src, err := readFile(overlay, position.Filename)
+	if err != nil {
+		src = nil
 	}
+	return columnOf(src, position, *unit)
+}
 
-	var end int
-	for offset := position.Offset; offset < len(src); offset++ {
-		if src[offset] == '\n' {
-			end = offset
-			break
-		}
+// readFileLine gives the line of position, without newline. It fails if
+// position is out of the file, which might be changed since it's searched.
+func readFileLine(overlay map[string][]byte, position token.Position) (string, error) {
+	src, err := readFile(overlay, position.Filename)
+	if err != nil {
+		return "", err
 	}
-	if end <= start {
-		return ""
+	if position.Offset < 0 || position.Offset >= len(src) {
+		return "", fmt.Errorf("offset %d is out of %s", position.Offset, position.Filename)
 	}
 
-	return string(src[start:end])
+	start := bytes.LastIndexByte(src[:position.Offset], '\n') + 1
+	end := len(src)
+	if i := bytes.IndexByte(src[position.Offset:], '\n'); i >= 0 {
+		end = position.Offset + i
+	}
+	return string(src[start:end]), nil
 }
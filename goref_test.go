@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -53,27 +54,55 @@ func TestAll(t *testing.T) {
 }
 
 type Configuration struct {
-	File   string
-	Offset int
-	Path   string
+	Command string // sub-command, like "rename"
+	File    string
+	Offset  int
+	Path    string
+	Flags   []string
+
+	Modified map[string]string // contents of unsaved files
 
 	Expected map[string]bool
+	Output   []string      // whole output, in order, instead of Expected
+	Records  []interface{} // lines of "-json" output, in order, only the given fields are compared
+	Stats    string        // last line of output, with "-stats"
+	Error    string        // part of error message, if the query fails
 }
 
 type ConfigJson map[string]interface{}
 
 func NewConfiguration(json *ConfigJson) *Configuration {
-	config := &Configuration{Expected: make(map[string]bool)}
+	config := &Configuration{Expected: make(map[string]bool), Modified: make(map[string]string)}
 
 	for k, v := range *json {
 		kk := strings.ToLower(k)
 		switch {
+		case kk == "command":
+			config.Command = v.(string)
 		case kk == "file":
 			config.File = v.(string)
 		case kk == "offset":
 			config.Offset = int(v.(float64))
 		case kk == "path":
 			config.Path = v.(string)
+		case kk == "flags":
+			for _, vv := range v.([]interface{}) {
+				config.Flags = append(config.Flags, vv.(string))
+			}
+		case kk == "modified":
+			for name, src := range v.(map[string]interface{}) {
+				config.Modified[name] = src.(string)
+			}
+		case kk == "error":
+			config.Error = v.(string)
+		case kk == "output":
+			for _, vv := range v.([]interface{}) {
+				config.Output = append(config.Output, vv.(string))
+			}
+		case kk == "records":
+			config.Records = v.([]interface{})
+		case kk == "stats":
+			config.Stats = v.(string)
 		case kk == "expected":
 			for _, vv := range v.([]interface{}) {
 				exp := vv.(string)
@@ -89,13 +118,17 @@ func (c *Configuration) String() string {
 	s := fmt.Sprintf("file: %s, ", c.File)
 	s += fmt.Sprintf("offset: %d, ", c.Offset)
 	s += fmt.Sprintf("path: %s, ", c.Path)
+	s += fmt.Sprintf("flags: %v, ", c.Flags)
+	s += fmt.Sprintf("modified: %v, ", c.ModifiedFiles())
 	s += fmt.Sprintf("expected: %v, ", c.Expected)
 	return s
 }
 
 func (c *Configuration) Prepare(pathPrefix string) (err error) {
 	c.File = filepath.Join(pathPrefix, c.File)
-	c.Path = filepath.Join(pathPrefix, c.Path)
+	if c.Path != "" {
+		c.Path = filepath.Join(pathPrefix, c.Path)
+	}
 
 	// You cannot manipulate keys while traverse them,
 	// make a deep copy to get key list, then manipulate its map
@@ -112,11 +145,75 @@ func (c *Configuration) Prepare(pathPrefix string) (err error) {
 		c.Expected[kk] = true
 	}
 
+	for _, record := range c.Records {
+		prefixRelNames(record, pathPrefix)
+	}
+
+	modified := c.ModifiedFiles()
+	for _, k := range modified {
+		src := c.Modified[k]
+		delete(c.Modified, k)
+
+		kk := filepath.Join(pathPrefix, k)
+		c.Modified[kk] = src
+	}
+
 	return
 }
 
+// prefixRelNames joins pathPrefix with "relname" of record, and of the
+// objects in it
+func prefixRelNames(record interface{}, pathPrefix string) {
+	fields, ok := record.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for k, v := range fields {
+		if name, ok := v.(string); ok && k == "relname" {
+			fields[k] = filepath.Join(pathPrefix, name)
+			continue
+		}
+		prefixRelNames(v, pathPrefix)
+	}
+}
+
+func (c *Configuration) ModifiedFiles() []string {
+	var names []string
+
+	for k := range c.Modified {
+		names = append(names, k)
+	}
+
+	return names
+}
+
+// Archive gives the contents of unsaved files in the format of "-modified"
+func (c *Configuration) Archive() string {
+	var archive string
+
+	for name, src := range c.Modified {
+		archive += fmt.Sprintf("%s\n%d\n%s", name, len(src), src)
+	}
+
+	return archive
+}
+
 func (c *Configuration) Pass(output string) bool {
+	if c.Output != nil {
+		return strings.TrimRight(output, "\n") == strings.Join(c.Output, "\n")
+	}
+	if c.Records != nil {
+		return c.passRecords(output)
+	}
+
 	results := strings.Split(strings.TrimSpace(output), "\n")
+	if c.Stats != "" {
+		if results[len(results)-1] != c.Stats {
+			return false
+		}
+		results = results[:len(results)-1]
+	}
 	if len(results) != len(c.Expected) {
 		return false
 	}
@@ -130,6 +227,43 @@ func (c *Configuration) Pass(output string) bool {
 	return true
 }
 
+// passRecords parses every line of output as JSON, which has to have the
+// fields of record in the same place
+func (c *Configuration) passRecords(output string) bool {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != len(c.Records) {
+		return false
+	}
+
+	for i, line := range lines {
+		var record interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil || !hasFields(record, c.Records[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasFields reports whether record has every field of want, with the same
+// value. Objects are compared field by field.
+func hasFields(record, want interface{}) bool {
+	fields, ok := want.(map[string]interface{})
+	if !ok {
+		return reflect.DeepEqual(record, want)
+	}
+
+	got, ok := record.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for k, v := range fields {
+		if !hasFields(got[k], v) {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *Configuration) Exps() []string {
 	var expected []string
 
@@ -155,6 +289,15 @@ func runTest(test string, wd string, t *testing.T) error {
 		return nil
 	}
 
+	// $TESTDIR in flags is a temporary directory, shared by test cases of
+	// one file
+	testDir, err := ioutil.TempDir("", "goref")
+	if err != nil {
+		failAt(t, base, "make temporary directory", err)
+		return nil
+	}
+	defer os.RemoveAll(testDir)
+
 	gorefPath := "goref"
 	for _, configJson := range f.([]interface{}) {
 		configJson := ConfigJson(configJson.(map[string]interface{}))
@@ -163,6 +306,9 @@ func runTest(test string, wd string, t *testing.T) error {
 			failAt(t, base, "unify names", err)
 			return nil
 		}
+		for i, flag := range config.Flags {
+			config.Flags[i] = strings.Replace(flag, "$TESTDIR", testDir, -1)
+		}
 
 		output, errout, err := runGorefCmd(gorefPath, config)
 		if err != nil {
@@ -171,12 +317,33 @@ func runTest(test string, wd string, t *testing.T) error {
 			return err
 		}
 
+		if config.Error != "" {
+			if !strings.Contains(errout, config.Error) || output != "" {
+				msg := fmt.Sprintf("\texpected error: \n\t\t%s\n", config.Error)
+				msg += fmt.Sprintf("\tactual: \n\t\t%s\n", indentOutput(output+errout))
+				name := fmt.Sprintf("%s, testcase #%v, name: '%v'", base, configJson["seq"], configJson["name"])
+				reportFailedTest(t, name, msg)
+			}
+			continue
+		}
+
 		if errout != "" || !config.Pass(output) {
 			if errout != "" {
 				output = errout
 			}
 
-			msg := fmt.Sprintf("\texpected: \n\t\t%s\n", strings.Join(config.Exps(), "\n\t\t"))
+			exps := config.Exps()
+			if config.Output != nil {
+				exps = config.Output
+			}
+			if config.Records != nil {
+				exps = nil
+				for _, record := range config.Records {
+					b, _ := json.Marshal(record)
+					exps = append(exps, string(b))
+				}
+			}
+			msg := fmt.Sprintf("\texpected: \n\t\t%s\n", strings.Join(exps, "\n\t\t"))
 			msg += fmt.Sprintf("\tactual: \n\t\t%s\n", indentOutput(output))
 			name := fmt.Sprintf("%s, testcase #%v, name: '%v'", base, configJson["seq"], configJson["name"])
 			reportFailedTest(t, name, msg)
@@ -188,7 +355,23 @@ func runTest(test string, wd string, t *testing.T) error {
 }
 
 func runGorefCmd(gorefPath string, config *Configuration) (string, string, error) {
-	command := exec.Command(gorefPath, "-R", "-f", config.File, "-o", fmt.Sprintf("%d", config.Offset), config.Path)
+	var args []string
+	if config.Command != "" {
+		args = append(args, config.Command)
+	}
+	// answered by the built goref, not by any running daemon
+	args = append(args, "-no-daemon", "-R", "-f", config.File, "-o", fmt.Sprintf("%d", config.Offset))
+	args = append(args, config.Flags...)
+	if len(config.Modified) != 0 {
+		args = append(args, "-modified")
+	}
+	if config.Path != "" {
+		args = append(args, config.Path)
+	}
+	command := exec.Command(gorefPath, args...)
+	if len(config.Modified) != 0 {
+		command.Stdin = strings.NewReader(config.Archive())
+	}
 	stdout, err := command.StdoutPipe()
 	if err != nil {
 		msg := fmt.Sprintf("failed to get stdout of 'goref' command, %v", err)
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"code.google.com/p/rog-go/exp/go/token"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/zhouhua015/goref/refs"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC and LSP error codes
+const (
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+	lspRequestFailed  = -32803
+)
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"` // in UTF-16 code units
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspHighlight struct {
+	Range lspRange `json:"range"`
+	Kind  int      `json:"kind"`
+}
+
+type lspTextDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+	Context  struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type lspDidChange struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"` // given by didOpen only
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+// lspServer answers LSP requests on stdio. Contents of open documents are
+// given to searches as modified files, imported packages are kept by cache
+// until they're changed.
+type lspServer struct {
+	root     string            // workspace directory, searched recursively
+	overlay  map[string][]byte // contents of open documents by file name
+	cache    *refs.Cache
+	logger   *log.Logger
+	shutdown bool
+
+	r *bufio.Reader
+	w io.Writer
+}
+
+// serveLSP runs a language server over stdin and stdout, supporting
+// textDocument/references and textDocument/documentHighlight.
+func serveLSP(logger *log.Logger) {
+	s := newLSPServer(os.Stdin, os.Stdout, logger)
+	if wd, err := os.Getwd(); err == nil {
+		s.root = wd
+	}
+
+	os.Exit(s.serve())
+}
+
+func newLSPServer(r io.Reader, w io.Writer, logger *log.Logger) *lspServer {
+	return &lspServer{
+		overlay: make(map[string][]byte),
+		cache:   refs.NewCache(),
+		logger:  logger,
+		r:       bufio.NewReader(r),
+		w:       w,
+	}
+}
+
+// serve answers requests until exit notification, gives the exit code
+func (s *lspServer) serve() int {
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return 1
+		}
+		if err != nil {
+			fail("cannot read LSP message, %v", err)
+		}
+
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+
+		result, rpcErr := s.handle(msg)
+		if msg.ID == nil {
+			// notifications are never answered
+			continue
+		}
+		if result == nil && rpcErr == nil {
+			result = json.RawMessage("null")
+		}
+		s.write(&lspMessage{JSONRPC: "2.0", ID: msg.ID, Result: result, Error: rpcErr})
+	}
+}
+
+func (s *lspServer) debugp(f string, a ...interface{}) {
+	if s.logger != nil {
+		s.logger.Printf(f, a...)
+	}
+}
+
+func (s *lspServer) handle(msg *lspMessage) (interface{}, *lspError) {
+	s.debugp("LSP: %s", msg.Method)
+
+	switch msg.Method {
+	case "initialize":
+		var params struct {
+			RootURI string `json:"rootUri"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		if root := uriToPath(params.RootURI); root != "" {
+			s.root = root
+		}
+
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":          1, // full contents on change
+				"referencesProvider":        true,
+				"documentHighlightProvider": true,
+			},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen", "textDocument/didChange":
+		var params lspDidChange
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+
+		filename := uriToPath(params.TextDocument.URI)
+		if msg.Method == "textDocument/didOpen" {
+			s.overlay[filename] = []byte(params.TextDocument.Text)
+		}
+		for _, change := range params.ContentChanges {
+			s.overlay[filename] = []byte(change.Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params lspDidChange
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+
+		delete(s.overlay, uriToPath(params.TextDocument.URI))
+		return nil, nil
+	case "textDocument/references", "textDocument/documentHighlight":
+		var params lspTextDocumentPosition
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+
+		if msg.Method == "textDocument/references" {
+			return s.references(&params)
+		}
+		return s.highlights(&params)
+	}
+
+	if msg.ID == nil {
+		return nil, nil
+	}
+	return nil, &lspError{lspMethodNotFound, "method not supported: " + msg.Method}
+}
+
+// references searches the whole workspace for references of identifier at
+// position, null if there isn't an identifier.
+func (s *lspServer) references(params *lspTextDocumentPosition) (interface{}, *lspError) {
+	filename := uriToPath(params.TextDocument.URI)
+	result, err := s.find(filename, params.Position, s.root, true)
+	if result == nil {
+		return nil, err
+	}
+
+	locations := make([]lspLocation, 0, len(result.References))
+	for _, ref := range result.References {
+		if !params.Context.IncludeDeclaration && ref.Start == result.DeclPos {
+			continue
+		}
+		locations = append(locations, lspLocation{pathToURI(ref.Start.Filename), s.lspRangeOf(ref)})
+	}
+	return locations, nil
+}
+
+// highlights searches the package of document for references of identifier
+// at position, the ones in the document are given. It's null if there isn't
+// an identifier.
+func (s *lspServer) highlights(params *lspTextDocumentPosition) (interface{}, *lspError) {
+	filename := uriToPath(params.TextDocument.URI)
+	result, err := s.find(filename, params.Position, filepath.Dir(filename), false)
+	if result == nil {
+		return nil, err
+	}
+
+	highlights := make([]lspHighlight, 0)
+	for _, ref := range result.References {
+		if ref.Start.Filename == filename {
+			kind := 2 // Read
+			if ref.Kind == refs.KindWrite {
+				kind = 3 // Write
+			}
+			highlights = append(highlights, lspHighlight{s.lspRangeOf(ref), kind})
+		}
+	}
+	return highlights, nil
+}
+
+// find searches path for identifier at position of document filename. The
+// result is nil if there isn't an identifier, and so is the error if it's
+// not a failure.
+func (s *lspServer) find(filename string, position lspPosition, path string, recurse bool) (*refs.Result, *lspError) {
+	src, err := readFile(s.overlay, filename)
+	if err != nil {
+		return nil, &lspError{lspInvalidParams, err.Error()}
+	}
+
+	offset, ok := offsetOfColumn(src, position.Line+1, position.Character+1, unitUTF16)
+	if !ok {
+		return nil, &lspError{lspInvalidParams, fmt.Sprintf("position %d:%d is out of %s", position.Line, position.Character, filename)}
+	}
+
+	result, err := refs.Find(context.Background(), refs.Query{
+		FileName: filename,
+		Offset:   offset,
+		Path:     path,
+		Recurse:  recurse,
+		Overlay:  s.overlay,
+		Cache:    s.cache,
+		Logger:   s.logger,
+	})
+	switch {
+	case err == refs.NoIdentifier || err == refs.FileExcluded:
+		return nil, nil
+	case err != nil:
+		return nil, &lspError{lspRequestFailed, err.Error()}
+	}
+	return result, nil
+}
+
+func (s *lspServer) lspRangeOf(ref refs.Reference) lspRange {
+	src, err := readFile(s.overlay, ref.Start.Filename)
+	if err != nil {
+		src = nil
+	}
+
+	return lspRange{lspPositionOf(src, ref.Start), lspPositionOf(src, ref.End)}
+}
+
+func lspPositionOf(src []byte, position token.Position) lspPosition {
+	return lspPosition{position.Line - 1, columnOf(src, position, unitUTF16) - 1}
+}
+
+func (s *lspServer) read() (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "Content-Length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+			if err != nil {
+				return nil, fmt.Errorf("invalid header %s", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.r, body); err != nil {
+		return nil, err
+	}
+
+	msg := &lspMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *lspServer) write(msg *lspMessage) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		fail("cannot encode LSP message, %v", err)
+	}
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+
+	// file names are compared with the ones processed the same way
+	name := filepath.FromSlash(u.Path)
+	if resolved, err := filepath.EvalSymlinks(name); err == nil {
+		name = resolved
+	}
+	return name
+}
+
+func pathToURI(filename string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(filename)}
+	return u.String()
+}
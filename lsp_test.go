@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLSP sends requests over stdio, and checks the responses in order
+func TestLSP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goref-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	write(t, filepath.Join(dir, "go.mod"), "module example.com/lsp\n")
+	write(t, filepath.Join(dir, "a", "a.go"), "package a\n\nfunc F() {}\n")
+	write(t, filepath.Join(dir, "b", "b.go"), "package b\n\nimport \"example.com/lsp/a\"\n\nfunc G() {\n\ta.F()\n\ta.F()\n}\n")
+	a, b := pathToURI(filepath.Join(dir, "a", "a.go")), pathToURI(filepath.Join(dir, "b", "b.go"))
+
+	at := func(uri string, line, character int) string {
+		return fmt.Sprintf(`{"textDocument":{"uri":%q},"position":{"line":%d,"character":%d},"context":{"includeDeclaration":true}}`,
+			uri, line, character)
+	}
+	rng := func(line, start, end int) string {
+		return fmt.Sprintf(`{"start":{"line":%d,"character":%d},"end":{"line":%d,"character":%d}}`, line, start, line, end)
+	}
+	tests := []struct {
+		method string
+		params string
+		want   string // result, or error code
+	}{
+		{"initialize", fmt.Sprintf(`{"rootUri":%q}`, pathToURI(dir)),
+			`{"capabilities":{"documentHighlightProvider":true,"referencesProvider":true,"textDocumentSync":1}}`},
+		{"textDocument/references", at(b, 5, 3),
+			fmt.Sprintf(`[{"uri":%q,"range":%s},{"uri":%q,"range":%s},{"uri":%q,"range":%s}]`,
+				a, rng(2, 5, 6), b, rng(5, 3, 4), b, rng(6, 3, 4))},
+		{"textDocument/documentHighlight", at(b, 6, 3),
+			fmt.Sprintf(`[{"range":%s,"kind":2},{"range":%s,"kind":2}]`, rng(5, 3, 4), rng(6, 3, 4))},
+		// keyword func
+		{"textDocument/documentHighlight", at(b, 4, 1), `null`},
+		{"textDocument/references", at(b, 4, 1), `null`},
+		{"textDocument/references", at(b, 20, 0), fmt.Sprint(lspInvalidParams)},
+		// F is renamed in the open document of imported package
+		{"textDocument/didOpen", fmt.Sprintf(`{"textDocument":{"uri":%q,"text":"package a\n\nfunc H() {}\n"}}`, a), ""},
+		{"textDocument/documentHighlight", at(b, 5, 3), fmt.Sprint(lspRequestFailed)},
+		{"textDocument/didClose", fmt.Sprintf(`{"textDocument":{"uri":%q}}`, a), ""},
+		{"textDocument/documentHighlight", at(b, 5, 3),
+			fmt.Sprintf(`[{"range":%s,"kind":2},{"range":%s,"kind":2}]`, rng(5, 3, 4), rng(6, 3, 4))},
+		{"shutdown", "null", `null`},
+	}
+
+	var in bytes.Buffer
+	id := 0
+	for _, test := range tests {
+		msg := fmt.Sprintf(`{"jsonrpc":"2.0","method":%q,"params":%s}`, test.method, test.params)
+		if test.want != "" {
+			id++
+			msg = fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q,"params":%s}`, id, test.method, test.params)
+		}
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	exit := `{"jsonrpc":"2.0","method":"exit"}`
+	fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(exit), exit)
+
+	var out bytes.Buffer
+	if code := newLSPServer(&in, &out, nil).serve(); code != 0 {
+		t.Errorf("exit code %d, want 0", code)
+	}
+
+	responses := newLSPServer(&out, nil, nil)
+	id = 0
+	for _, test := range tests {
+		if test.want == "" {
+			continue
+		}
+		id++
+
+		resp, err := responses.read()
+		if err != nil {
+			t.Fatalf("cannot read response of %s, %v", test.method, err)
+		}
+		var got string
+		if resp.Error != nil {
+			got = fmt.Sprint(resp.Error.Code)
+		} else {
+			b, _ := json.Marshal(resp.Result)
+			got = string(b)
+		}
+		if respID, _ := json.Marshal(resp.ID); string(respID) != fmt.Sprint(id) {
+			t.Errorf("%s: response id %s, want %d", test.method, respID, id)
+		}
+		if got != normalized(test.want) {
+			t.Errorf("%s %s:\n\tgot  %s\n\twant %s", test.method, test.params, got, test.want)
+		}
+	}
+}
+
+// normalized gives JSON text as it's encoded from generic values, with keys
+// of objects sorted
+func normalized(text string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(text), &v); err != nil {
+		return text
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"code.google.com/p/rog-go/exp/go/token"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// units of columns
+const (
+	unitBytes = "bytes"
+	unitRunes = "runes"
+	unitUTF16 = "utf16"
+)
+
+func isUnit(unit string) bool {
+	return unit == unitBytes || unit == unitRunes || unit == unitUTF16
+}
+
+// width gives the length of src, in unit
+func width(src []byte, unit string) int {
+	switch unit {
+	case unitRunes:
+		return utf8.RuneCount(src)
+	case unitUTF16:
+		return len(utf16.Encode(bytes.Runes(src)))
+	}
+	return len(src)
+}
+
+// parsePos parses position like "file.go:line:column", and converts it into
+// file name and byte offset, column is counted in unit, starting from 1.
+func parsePos(pos string, unit string, overlay map[string][]byte) (string, int, error) {
+	i := strings.LastIndex(pos, ":")
+	j := -1
+	if i > 0 {
+		j = strings.LastIndex(pos[:i], ":")
+	}
+	if j <= 0 {
+		return "", 0, fmt.Errorf("invalid position %s, want file:line:column", pos)
+	}
+
+	line, err := strconv.Atoi(pos[j+1 : i])
+	if err != nil || line < 1 {
+		return "", 0, fmt.Errorf("invalid line of position %s", pos)
+	}
+	column, err := strconv.Atoi(pos[i+1:])
+	if err != nil || column < 1 {
+		return "", 0, fmt.Errorf("invalid column of position %s", pos)
+	}
+
+	// file names are compared with the ones processed the same way
+	filename := pos[:j]
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = resolved
+	}
+	src, err := readFile(overlay, filename)
+	if err != nil {
+		return "", 0, err
+	}
+
+	offset, ok := offsetOfColumn(src, line, column, unit)
+	if !ok {
+		return "", 0, fmt.Errorf("position %s is out of file", pos)
+	}
+	return filename, offset, nil
+}
+
+// offsetOfColumn gives byte offset of line and column in src, both start
+// from 1, column is counted in unit.
+func offsetOfColumn(src []byte, line, column int, unit string) (int, bool) {
+	offset := 0
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(src[offset:], '\n')
+		if i < 0 {
+			return 0, false
+		}
+		offset += i + 1
+	}
+
+	for n := 1; n < column; {
+		if offset >= len(src) || src[offset] == '\n' {
+			return 0, false
+		}
+		_, size := utf8.DecodeRune(src[offset:])
+		n += width(src[offset:offset+size], unit)
+		offset += size
+	}
+	return offset, true
+}
+
+// columnOf gives column of position, counted in unit. src is the contents
+// of file, if it's nil, the byte column of position is given.
+func columnOf(src []byte, position token.Position, unit string) int {
+	start := position.Offset - (position.Column - 1)
+	if src == nil || unit == unitBytes || start < 0 || position.Offset > len(src) {
+		return position.Column
+	}
+
+	return width(src[start:position.Offset], unit) + 1
+}
+
+func readFile(overlay map[string][]byte, filename string) ([]byte, error) {
+	if src, ok := overlay[filename]; ok {
+		return src, nil
+	}
+
+	return ioutil.ReadFile(filename)
+}
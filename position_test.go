@@ -0,0 +1,64 @@
+package main
+
+import (
+	"code.google.com/p/rog-go/exp/go/token"
+	"testing"
+)
+
+// "é" takes 2 bytes, 1 rune and 1 UTF-16 code unit, "𝔀" takes 4 bytes,
+// 1 rune and 2 UTF-16 code units.
+var columnSrc = []byte("package p\n\tx, é𝔀 := 1, 2\n")
+
+var columnTests = []struct {
+	unit   string
+	column int
+	offset int
+}{
+	{unitBytes, 1, 10},
+	{unitBytes, 5, 14},
+	{unitBytes, 7, 16},
+	{unitBytes, 11, 20},
+	{unitRunes, 1, 10},
+	{unitRunes, 5, 14},
+	{unitRunes, 6, 16},
+	{unitRunes, 7, 20},
+	{unitUTF16, 1, 10},
+	{unitUTF16, 5, 14},
+	{unitUTF16, 6, 16},
+	{unitUTF16, 8, 20},
+}
+
+func TestOffsetOfColumn(t *testing.T) {
+	for _, test := range columnTests {
+		offset, ok := offsetOfColumn(columnSrc, 2, test.column, test.unit)
+		if !ok || offset != test.offset {
+			t.Errorf("column %d in %s: got offset %d, %v, want %d", test.column, test.unit, offset, ok, test.offset)
+		}
+	}
+
+	for _, test := range []struct {
+		line, column int
+	}{
+		{3, 2},
+		{2, 30},
+		{1, 11},
+	} {
+		if offset, ok := offsetOfColumn(columnSrc, test.line, test.column, unitBytes); ok {
+			t.Errorf("%d:%d: got offset %d, want out of range", test.line, test.column, offset)
+		}
+	}
+}
+
+func TestColumnOf(t *testing.T) {
+	for _, test := range columnTests {
+		position := token.Position{Line: 2, Column: test.offset - 10 + 1, Offset: test.offset}
+		if column := columnOf(columnSrc, position, test.unit); column != test.column {
+			t.Errorf("offset %d in %s: got column %d, want %d", test.offset, test.unit, column, test.column)
+		}
+	}
+
+	position := token.Position{Line: 2, Column: 11, Offset: 20}
+	if column := columnOf(nil, position, unitUTF16); column != 11 {
+		t.Errorf("without source: got column %d, want byte column 11", column)
+	}
+}
@@ -0,0 +1,168 @@
+package refs
+
+import (
+	"bytes"
+	"code.google.com/p/rog-go/exp/go/token"
+	"context"
+	"errors"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var FileExcluded = errors.New("file is excluded by build constraints, check tags, GOOS and GOARCH")
+
+// knownConfigs are the GOOS/GOARCH pairs searched for Query.AllConfigs
+var knownConfigs = [][2]string{
+	{"linux", "amd64"}, {"linux", "386"}, {"linux", "arm"}, {"linux", "arm64"},
+	{"linux", "ppc64le"}, {"linux", "s390x"}, {"linux", "mips"}, {"linux", "riscv64"},
+	{"darwin", "amd64"}, {"darwin", "arm64"}, {"ios", "arm64"}, {"android", "arm64"},
+	{"windows", "amd64"}, {"windows", "386"}, {"windows", "arm64"},
+	{"freebsd", "amd64"}, {"netbsd", "amd64"}, {"openbsd", "amd64"}, {"dragonfly", "amd64"},
+	{"solaris", "amd64"}, {"illumos", "amd64"}, {"aix", "ppc64"}, {"plan9", "amd64"},
+	{"js", "wasm"},
+}
+
+// buildContext gives the build context of q for goos and goarch, the ones
+// of build.Default are used if they're empty.
+func (q *Query) buildContext(goos, goarch string) *build.Context {
+	bctx := build.Default
+	if goos != "" {
+		bctx.GOOS = goos
+	}
+	if goarch != "" {
+		bctx.GOARCH = goarch
+	}
+	bctx.BuildTags = q.Tags
+
+	return &bctx
+}
+
+// findAllConfigs searches in every known build configuration which selects
+// a different set of files, references found are merged. Configurations
+// excluding the file of identifier are skipped.
+func findAllConfigs(ctx context.Context, q Query) (*Result, error) {
+	// files of search path are not known until the root is found, every
+	// configuration is searched then
+	var filenames []string
+	if q.Path != "" || q.Scope != ScopeImporters {
+		path, err := realPath(q.Path)
+		if err != nil {
+			return nil, errorGenerator("cannot resolve path %s, %v", q.Path, err)
+		}
+		if filenames, err = getFileNames(q.Recurse || q.Scope == ScopeImporters, path, q.Overlay); err != nil {
+			return nil, errorGenerator("cannot find any go file in %s, %v", path, err)
+		}
+	}
+
+	var merged *Result
+	var firstErr error
+	searched := make(map[string]bool)
+	seen := make(map[token.Position]bool)
+	for _, config := range knownConfigs {
+		bctx := q.buildContext(config[0], config[1])
+
+		var selected []string
+		for _, filename := range filenames {
+			if matchFile(bctx, q.Overlay, filename) {
+				selected = append(selected, filename)
+			}
+		}
+		key := strings.Join(selected, "\n")
+		if filenames == nil {
+			key = config[0] + "/" + config[1]
+		}
+		if searched[key] {
+			continue
+		}
+		searched[key] = true
+
+		result, err := find(ctx, q, bctx)
+		if err == FileExcluded {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if merged == nil {
+			merged = &Result{Name: result.Name, Kind: result.Kind, Package: result.Package, DeclPos: result.DeclPos, DeclEnd: result.DeclEnd}
+		}
+		merged.Stats.add(result.Stats)
+		for _, ref := range result.References {
+			if !seen[ref.Start] {
+				seen[ref.Start] = true
+				merged.References = append(merged.References, ref)
+			}
+		}
+	}
+
+	if merged == nil {
+		if firstErr == nil {
+			firstErr = FileExcluded
+		}
+		return nil, firstErr
+	}
+
+	sort.Sort(byPosition(merged.References))
+	return merged, nil
+}
+
+// matchFile reports whether filename is selected by bctx, by its name and
+// build constraints. Every file is selected if bctx is nil, and the ones
+// can't be checked, their errors are found by parsing.
+func matchFile(bctx *build.Context, overlay map[string][]byte, filename string) bool {
+	if bctx == nil {
+		return true
+	}
+
+	c := *bctx
+	c.OpenFile = func(path string) (io.ReadCloser, error) {
+		if src, ok := overlay[path]; ok {
+			return ioutil.NopCloser(bytes.NewReader(src)), nil
+		}
+		return os.Open(path)
+	}
+
+	ok, err := c.MatchFile(filepath.Dir(filename), filepath.Base(filename))
+	return err != nil || ok
+}
+
+func (ctx *Context) matchFile(filename string) bool {
+	if ctx.SkipTests && isTestFile(filename) {
+		return false
+	}
+
+	return matchFile(ctx.Build, ctx.Overlay, filename)
+}
+
+// filterFiles gives the files selected by ctx.Build and ctx.SkipTests
+func (ctx *Context) filterFiles(filenames []string) []string {
+	var selected []string
+	for _, filename := range filenames {
+		if ctx.matchFile(filename) {
+			selected = append(selected, filename)
+		}
+	}
+
+	return selected
+}
+
+// buildConfig describes bctx, for index keys
+func buildConfig(bctx *build.Context) string {
+	if bctx == nil {
+		return "all"
+	}
+
+	return bctx.GOOS + "/" + bctx.GOARCH + " " + strings.Join(bctx.BuildTags, ",")
+}
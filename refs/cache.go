@@ -0,0 +1,101 @@
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/token"
+	"os"
+	"time"
+)
+
+// maxCacheBase bounds the file set of Cache, it's about the size of all
+// files parsed in bytes
+const maxCacheBase = 64 << 20
+
+// Cache keeps packages imported by searches for the following ones, like
+// daemon does. A package is parsed again once any of its files is changed
+// on disk, it's not kept while any of them is modified. The file set is
+// replaced, with every package dropped, once it has grown over
+// maxCacheBase. Searches sharing a Cache must not run concurrently.
+type Cache struct {
+	fset *token.FileSet
+	pkgs map[string]*cachedPackage // by loading configuration and directory
+}
+
+type cachedPackage struct {
+	pkg   *ast.Package
+	files map[string]fileStamp // stamps of files by file name
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func NewCache() *Cache {
+	return &Cache{fset: token.NewFileSet(), pkgs: make(map[string]*cachedPackage)}
+}
+
+// fileSet gives the file set of a search, a new one if it has grown too
+// large
+func (c *Cache) fileSet() *token.FileSet {
+	if c.fset.Base() > maxCacheBase {
+		c.fset = token.NewFileSet()
+		c.pkgs = make(map[string]*cachedPackage)
+	}
+
+	return c.fset
+}
+
+// lookup gives the package kept by key, nil if there isn't one, or any of
+// filenames is changed or modified.
+func (c *Cache) lookup(key string, filenames []string, overlay map[string][]byte) *ast.Package {
+	if c == nil {
+		return nil
+	}
+
+	cached, ok := c.pkgs[key]
+	if !ok || len(cached.files) != len(filenames) {
+		return nil
+	}
+	for _, filename := range filenames {
+		stamp, ok := stampOf(filename, overlay)
+		if !ok || cached.files[filename] != stamp {
+			return nil
+		}
+	}
+
+	return cached.pkg
+}
+
+// store keeps pkg parsed from filenames by key, unless any of them is
+// modified.
+func (c *Cache) store(key string, pkg *ast.Package, filenames []string, overlay map[string][]byte) {
+	if c == nil {
+		return
+	}
+
+	cached := &cachedPackage{pkg: pkg, files: make(map[string]fileStamp)}
+	for _, filename := range filenames {
+		stamp, ok := stampOf(filename, overlay)
+		if !ok {
+			delete(c.pkgs, key)
+			return
+		}
+		cached.files[filename] = stamp
+	}
+	c.pkgs[key] = cached
+}
+
+// stampOf gives the stamp of file on disk, false if it's modified or can't
+// be found
+func stampOf(filename string, overlay map[string][]byte) (fileStamp, bool) {
+	if _, ok := overlay[filename]; ok {
+		return fileStamp{}, false
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return fileStamp{}, false
+	}
+	return fileStamp{info.ModTime(), info.Size()}, true
+}
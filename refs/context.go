@@ -0,0 +1,848 @@
+package refs
+
+import (
+	"bytes"
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/parser"
+	"code.google.com/p/rog-go/exp/go/printer"
+	"code.google.com/p/rog-go/exp/go/token"
+	"code.google.com/p/rog-go/exp/go/types"
+	"container/list"
+	"errors"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var NoMorePkgFiles = errors.New("no more package files found")
+
+// NoIdentifier is given if there isn't an identifier at the search position
+var NoIdentifier = errors.New("cannot find identifier")
+
+// debugger prints debug messages to log, if it's not nil
+type debugger struct {
+	log *log.Logger
+}
+
+func (d debugger) debugp(f string, a ...interface{}) {
+	if d.log != nil {
+		d.log.Printf(f, a...)
+	}
+}
+
+// search modes
+const (
+	ModeRefs       = "refs"       // references of the subject
+	ModeImplements = "implements" // implementations of interface, or interfaces satisfied by type
+)
+
+type Context struct {
+	FileName  string
+	SearchPos int
+	Path      string
+	Mode      string
+	LocalPkg  *ast.Package
+
+	Scope   ast.Node // search scope
+	Subject Subject
+
+	// contents of modified files, which are not saved yet, by file name
+	Overlay map[string][]byte
+
+	// match methods through interface dynamic dispatch, too
+	Dispatch bool
+
+	// called with every reference found, the name of function declaration
+	// which encloses it, empty if there isn't one, and kind of reference
+	RefPrinter func(n ast.Expr, fn string, kind string)
+
+	// debug messages are written to Logger, if it's not nil
+	Logger *log.Logger
+
+	// scanning stops once Cancel is closed, if it's not nil
+	Cancel <-chan struct{}
+
+	// files are selected by build constraints for it, every file is
+	// selected if it's nil
+	Build *build.Context
+
+	// _test.go files are not selected
+	SkipTests bool
+
+	// package names are searched in every file importing the package, not
+	// just the one of the subject
+	Importing bool
+
+	// number of goroutines parsing packages and scanning files, 1 if it's
+	// not positive. Files of one package are parsed one by one, parser
+	// fills the package scope shared by them. Files are scanned one by one
+	// too, resolving types only reads the parsed files, and packages are
+	// imported under the lock of loader. RefPrinter is never called
+	// concurrently.
+	Jobs int
+
+	// how much work has been done
+	Stats Stats
+
+	pruned   map[string]bool // files can't refer the subject, not scanned
+	printing sync.Mutex      // serializes RefPrinter
+
+	*loader // imported packages, and the file set of search
+}
+
+// NewContext gives the context of search in path, with every file selected.
+// Imports are resolved by the go.mod enclosing path, or in $GOPATH and
+// GOROOT.
+func NewContext(source string, pos int, path string) *Context {
+	return &Context{FileName: source, SearchPos: pos, Path: path, Mode: ModeRefs, loader: newLoader(path, nil, nil, nil, nil)}
+}
+
+func (ctx *Context) debugp(f string, a ...interface{}) {
+	ctx.newDebugger().debugp(f, a...)
+}
+
+func (ctx *Context) newDebugger() debugger {
+	return debugger{ctx.Logger}
+}
+
+func (ctx *Context) String() string {
+	s := fmt.Sprintf("subject: %v, ", ctx.Subject)
+	if ctx.LocalPkg != nil {
+		s += fmt.Sprintf("local package name: %v, ", ctx.LocalPkg.Name)
+	}
+	s += fmt.Sprintf("search scope: %T %v, ", ctx.Scope, ctx.Scope)
+
+	return s
+}
+
+func (ctx *Context) ParseSubject() error {
+	src, err := ctx.ReadFile(ctx.FileName)
+	if err != nil {
+		return errorGenerator("cannot read %s: %v", ctx.FileName, err)
+	}
+
+	pkgScope := ast.NewScope(parser.Universe)
+	f, err := parser.ParseFile(ctx.fset, ctx.FileName, src, 0, pkgScope)
+	if f == nil {
+		return errorGenerator("cannot parse %s: %v", ctx.FileName, err)
+	}
+	ctx.rewriteVendorImports(f, filepath.Dir(ctx.FileName))
+
+	spec := ctx.findImportSpec(f, ctx.SearchPos)
+	identifier, fdecl, err := ctx.findIdentifier(f, ctx.SearchPos)
+	// import paths are subjects of refs mode only
+	if err != nil && (spec == nil || ctx.Mode != ModeRefs) {
+		return err
+	}
+	ctx.debugp("target: %T %v\n", identifier, identifier)
+
+	// add local package
+	pkg, perr := ctx.parseLocalPackage(ctx.FileName, f, pkgScope)
+	if pkg == nil && perr != NoMorePkgFiles {
+		ctx.debugp("parseLocalPackage error: %v", perr)
+	}
+	ctx.LocalPkg = pkg
+
+	if spec != nil && ctx.Mode == ModeRefs {
+		return ctx.usePkgName(f, spec)
+	}
+
+	// labels have no objects, they're found in the enclosing function
+	if label := ctx.findLabel(f, identifier); label != nil {
+		ctx.Subject = label
+		ctx.Scope = label.fn
+		ctx.debugp("subject is label %v", label)
+		return nil
+	}
+
+	// and try again...
+	obj, typ := ctx.exprType(identifier, ctx.LocalPkg)
+	if ident, ok := identifier.(*ast.Ident); ok && obj == nil {
+		// might be an identifier of package imported to "."
+		for _, spec := range importsOf(f) {
+			if !isDotImport(spec) {
+				continue
+			}
+			e := dotImportedSelector(ident, spec)
+			if obj, typ = ctx.exprType(e, ctx.LocalPkg); obj != nil {
+				identifier = e
+				break
+			}
+		}
+	}
+	if ident, ok := identifier.(*ast.Ident); ok && typ.Kind == ast.Pkg && ctx.Mode == ModeRefs {
+		// qualifier of imported package
+		if spec := ctx.importSpecOf(f, typ, ident.Name); spec != nil {
+			return ctx.usePkgName(f, spec)
+		}
+	}
+	sw := ctx.findTypeSwitch(f, identifier, obj)
+	if sw == nil && (obj == nil || typ.Kind == ast.Bad) {
+		return errorGenerator("identifier with nil object, %T %v\n", identifier, identifier)
+	}
+	ctx.debugp("source type %v, type node %s", typ, pretty(typ.Node))
+
+	switch {
+	case ctx.Mode == ModeImplements:
+		ctx.Subject, err = ctx.newImplSub(identifier, typ, obj)
+	case sw != nil:
+		// symbol of type switch is declared implicitly in every case
+		// clause, with different objects and types
+		ctx.debugp("subject is symbol of type switch %v", sw)
+		ctx.Subject = &identSub{debugger: ctx.newDebugger(), loader: ctx.loader,
+			self:       identifier.(*ast.Ident),
+			typ:        typ,
+			obj:        obj,
+			typeSwitch: sw}
+	default:
+		err = ctx.buildSubject(identifier, f, typ, obj)
+	}
+	if err != nil {
+		return err
+	}
+
+	// try to get scope
+	declPos := ctx.Subject.DeclPos()
+	if fdecl != nil && fdecl.Pos() <= declPos && declPos <= fdecl.End() {
+		ctx.Scope = fdecl
+	}
+
+	// update subject package name, by the declaration position.
+	// The types.Expr() might get the wrong package name if
+	// it's parsing local package
+	ctx.Subject.Toast()
+
+	ctx.debugp("context after subject parsed %v", ctx)
+	return nil
+}
+
+func (ctx *Context) buildSubject(identifier ast.Expr, f *ast.File, typ types.Type, obj *ast.Object) error {
+	// try to get recv if the ident is field/function declaration
+	switch t := identifier.(type) {
+	case *ast.SelectorExpr:
+		recv := ctx.typeOf(t.X, ctx.LocalPkg)
+		if owner, ok := ctx.ownerOf(recv, t.Sel.Name); ok {
+			// the selected one might be a promoted field or method
+			recv = owner
+		}
+		ctx.debugp("subject recv type: %v", recv)
+		ctx.Subject = &selectorSub{debugger: ctx.newDebugger(), loader: ctx.loader,
+			self:     t,
+			typ:      typ,
+			obj:      obj,
+			recv:     recv,
+			dispatch: ctx.Dispatch}
+	case *ast.Ident:
+		// *ast.Ident might be
+		//    1. method decl of struct
+		//    2. field decl of struct
+		// find recv for these 2 types
+		switch d := obj.Decl.(type) {
+		case *ast.FuncDecl:
+			ctx.debugp("source object decl is a FuncDecl, name: %s", d.Name.Name)
+			if d.Recv != nil {
+				if len(d.Recv.List) != 1 {
+					return errorGenerator("Invalid ident")
+				}
+				ctx.debugp("source object decl is a FuncDecl, recv: %v", d.Recv.List[0])
+
+				e := &ast.SelectorExpr{X: d.Recv.List[0].Type, Sel: t}
+				ctx.debugp("subject recv type: %v", ctx.typeOf(d.Recv.List[0].Type, ctx.LocalPkg))
+				ctx.Subject = &selectorSub{debugger: ctx.newDebugger(), loader: ctx.loader,
+					self:     e,
+					typ:      typ,
+					obj:      obj,
+					recv:     ctx.typeOf(d.Recv.List[0].Type, ctx.LocalPkg),
+					dispatch: ctx.Dispatch}
+			}
+		case *ast.Field:
+			ctx.debugp("source object decl is a Field, name: %v", d.Names)
+			owner := ctx.findFieldOuter(d, f)
+			if owner == nil {
+				ctx.Subject = &identSub{debugger: ctx.newDebugger(), loader: ctx.loader, self: t, typ: typ, obj: obj}
+				break
+			}
+			e := &ast.SelectorExpr{X: owner, Sel: t}
+			ctx.debugp("subject recv type: %v", ctx.typeOf(owner, ctx.LocalPkg))
+			ctx.Subject = &selectorSub{debugger: ctx.newDebugger(), loader: ctx.loader,
+				self:     e,
+				typ:      typ,
+				obj:      obj,
+				recv:     ctx.typeOf(owner, ctx.LocalPkg),
+				dispatch: ctx.Dispatch}
+		}
+
+		// function without any recv have to be a ident subject
+		if ctx.Subject == nil {
+			ctx.Subject = &identSub{debugger: ctx.newDebugger(), loader: ctx.loader, self: t, typ: typ, obj: obj}
+		}
+	}
+
+	if ctx.Subject == nil {
+		return errorGenerator("failed to parse subject")
+	}
+	ctx.debugp("subject %v", ctx.Subject)
+
+	return nil
+}
+
+type inspector func(ast.Node) bool
+
+func (ctx *Context) Scan(n ast.Node, pkg *ast.Package) {
+	var visit inspector
+	ok := true
+	inCompositeLit := false
+	compositeLitTypStack := list.New()
+	var dotImports []*ast.ImportSpec
+	fn := funcName(n) // name of the enclosing function declaration
+	uses := newUseKinds(n)
+	visit = func(n ast.Node) bool {
+		if !ok {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.ImportSpec:
+			// Unresolved identifiers might be the package level ones
+			// of the packages imported to "."
+			if isDotImport(n) {
+				dotImports = append(dotImports, n)
+			}
+			if subject, isPkgName := ctx.Subject.(*pkgNameSub); isPkgName {
+				if subject.importedBy(n) {
+					ctx.printRef(importNameOf(n), fn, KindImport)
+				}
+				return false
+			}
+			return true
+		case *ast.Ident:
+			if len(dotImports) != 0 {
+				if obj, _ := ctx.exprType(n, pkg); obj == nil {
+					ok = ctx.visitDotImported(n, fn, uses, dotImports, pkg)
+					return false
+				}
+			}
+			ok = ctx.visitExpr(n, fn, uses, pkg)
+			return false
+		case *ast.KeyValueExpr:
+			// don't try to resolve the key part of a key-value
+			// because it might be a map key which doesn't
+			// need resolving, and we can't tell without being
+			// complicated with types.
+			ast.Inspect(n.Value, visit)
+			return false
+		case *ast.SelectorExpr:
+			// don't visit expr of selector if we're in composite literal,
+			// it has been visited in visitCompositeLit() function
+			if !inCompositeLit {
+				ast.Inspect(n.X, visit)
+			}
+			ok = ctx.visitExpr(n, fn, uses, pkg)
+			return false
+		case *ast.CompositeLit:
+			inCompositeLit = true
+			ctx.visitCompositeLit(n, compositeLitTypStack, visit)
+			inCompositeLit = false
+			return false
+		case *ast.File:
+			for _, d := range n.Decls {
+				fn = funcName(d)
+				ast.Inspect(d, visit)
+			}
+			fn = ""
+			return false
+		}
+
+		return true
+	}
+
+	ast.Inspect(n, visit)
+}
+
+func (ctx *Context) canceled() bool {
+	select {
+	case <-ctx.Cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ctx *Context) ScanFiles(filenames []string) error {
+	return ctx.scanFiles(filenames, nil)
+}
+
+// scanFiles parses all the files for type resolving, but scans only the
+// ones accepted by scan, or all of them if scan is nil.
+func (ctx *Context) scanFiles(filenames []string, scan func(filename string) bool) error {
+	if ctx.Scope != nil {
+		ctx.Scan(ctx.Scope, ctx.LocalPkg)
+		return nil
+	}
+
+	ctx.Stats.Parsed += len(filenames)
+	pkgs, err := ctx.parseFiles(filenames)
+	if err != nil {
+		return errorGenerator("cannot parse files, %v", err)
+	}
+	if len(pkgs) == 0 {
+		return errorGenerator("cannot find any packages in given files")
+	}
+
+	type job struct {
+		filename string
+		pkg      *ast.Package
+	}
+	var jobs []job
+	for _, pkg := range pkgs {
+		for filename := range pkg.Files {
+			if ctx.pruned[filename] || scan != nil && !scan(filename) {
+				continue
+			}
+			jobs = append(jobs, job{filename, pkg})
+		}
+	}
+
+	ctx.parallel(len(jobs), func(i int) {
+		ctx.debugp("Scan file: %s", jobs[i].filename)
+		ctx.Scan(jobs[i].pkg.Files[jobs[i].filename], jobs[i].pkg)
+	})
+	ctx.Stats.Scanned += len(jobs)
+	return nil
+}
+
+// parseFiles works like parser.ParseFiles, except the contents of
+// modified files are taken from the overlay, and files are parsed by
+// ctx.Jobs goroutines. Files of one package share the package scope,
+// they're parsed one by one. Parsed files are counted by the callers
+// searching them.
+func (ctx *Context) parseFiles(filenames []string) (map[string]*ast.Package, error) {
+	srcs := make([][]byte, len(filenames))
+	names := make([]string, len(filenames))
+	errs := make([]error, len(filenames))
+	ctx.parallel(len(filenames), func(i int) {
+		src, err := ctx.ReadFile(filenames[i])
+		if err != nil {
+			errs[i] = err
+			return
+		}
+
+		clause, err := parser.ParseFile(token.NewFileSet(), filenames[i], src, parser.PackageClauseOnly, nil)
+		if clause == nil {
+			errs[i] = err
+			return
+		}
+		srcs[i], names[i] = src, clause.Name.Name
+	})
+
+	// group files by package, which is named by directory and package
+	// name, so external test packages are separated, in the given order
+	var order []string
+	pkgs := make(map[string]*ast.Package)
+	files := make(map[string][]int)
+	for i, name := range names {
+		if errs[i] != nil {
+			continue
+		}
+
+		key := filepath.Join(filepath.Dir(filenames[i]), name)
+		if _, ok := pkgs[key]; !ok {
+			pkgs[key] = &ast.Package{Name: name, Scope: ast.NewScope(parser.Universe), Files: make(map[string]*ast.File)}
+			order = append(order, key)
+		}
+		files[key] = append(files[key], i)
+	}
+
+	ctx.parallel(len(order), func(k int) {
+		pkg := pkgs[order[k]]
+		for _, i := range files[order[k]] {
+			f, err := parser.ParseFile(ctx.fset, filenames[i], srcs[i], 0, pkg.Scope)
+			if err != nil {
+				errs[i] = err
+			}
+			if f != nil {
+				ctx.rewriteVendorImports(f, filepath.Dir(filenames[i]))
+				pkg.Files[filenames[i]] = f
+			}
+		}
+	})
+
+	ctx.registerTestedImports(pkgs)
+
+	for _, err := range errs {
+		if err != nil {
+			return pkgs, err
+		}
+	}
+	return pkgs, nil
+}
+
+// parallel calls work with 0, 1, ..., n-1, by at most ctx.Jobs goroutines.
+// It stops calling work once ctx is canceled.
+func (ctx *Context) parallel(n int, work func(int)) {
+	jobs := ctx.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				work(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n && !ctx.canceled(); i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// ReadFile reads the content of file, from the overlay if it's modified,
+// or from disk.
+func (ctx *Context) ReadFile(filename string) ([]byte, error) {
+	if src, ok := ctx.Overlay[filename]; ok {
+		return src, nil
+	}
+
+	return ioutil.ReadFile(filename)
+}
+
+func (ctx *Context) WhereIs(n ast.Expr) token.Position {
+	switch n := n.(type) {
+	default:
+		return ctx.fset.Position(n.Pos())
+	case *ast.SelectorExpr:
+		return ctx.fset.Position(n.Sel.Pos())
+	}
+}
+
+// WhereEnds gives the position right after the name of reference
+func (ctx *Context) WhereEnds(n ast.Expr) token.Position {
+	return ctx.fset.Position(n.End())
+}
+
+// To selector subject, the declaration position will not be visited as
+// a selector, which require this method to let caller access the declaration
+// position
+func (ctx *Context) SubjectDeclPos() (token.Position, bool) {
+	// TODO I'm a terrible workaround, fix me!
+	if _, ok := ctx.Subject.(*selectorSub); ok {
+		// if decl file is in search path, give decl position as
+		// a reference, too.
+		subPosition := ctx.fset.Position(ctx.Subject.DeclPos())
+		dir := filepath.Dir(subPosition.Filename)
+		if strings.HasPrefix(dir, ctx.Path) {
+			return subPosition, true
+		}
+	}
+
+	return ctx.fset.Position(token.NoPos), false
+}
+
+func (ctx *Context) visitExpr(n ast.Expr, fn string, uses useKinds, pkg *ast.Package) bool {
+	ctx.debugp("visit expr, %T %v", n, n)
+	if ctx.Subject.IsMe(n, pkg) {
+		ctx.printRef(n, fn, uses.kindOf(n, ctx.Subject))
+	}
+
+	return true
+}
+
+func (ctx *Context) printRef(n ast.Expr, fn string, kind string) {
+	ctx.printing.Lock()
+	defer ctx.printing.Unlock()
+
+	ctx.RefPrinter(n, fn, kind)
+}
+
+// visitDotImported visits an unresolved identifier as a qualified one,
+// of each package imported to ".".
+func (ctx *Context) visitDotImported(n *ast.Ident, fn string, uses useKinds, dotImports []*ast.ImportSpec, pkg *ast.Package) bool {
+	for _, spec := range dotImports {
+		e := dotImportedSelector(n, spec)
+		ctx.debugp("visit dot imported expr, %T %v", e, e)
+		if ctx.Subject.IsMe(e, pkg) {
+			ctx.printRef(e, fn, uses.kindOf(e, ctx.Subject))
+			break
+		}
+	}
+
+	return true
+}
+
+// ----------------------------------------------------------------------
+func errorGenerator(format string, a ...interface{}) error {
+	return errors.New(fmt.Sprintf(format, a...))
+}
+
+func pretty(n ast.Node) string {
+	var b bytes.Buffer
+	var emptyFileSet = token.NewFileSet()
+	printer.Fprint(&b, emptyFileSet, n)
+	return b.String()
+}
+
+func (ctx *Context) findIdentifier(f *ast.File, searchpos int) (e ast.Expr, fdecl *ast.FuncDecl, err error) {
+	found := false
+
+	var visit inspector
+	compositeLitTypStack := list.New()
+	visit = func(n ast.Node) bool {
+		if found {
+			return false
+		}
+		var startPos token.Pos
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			ast.Inspect(n.Name, visit)
+
+			fdecl = n
+			if n.Recv != nil {
+				ast.Inspect(n.Recv, visit)
+			}
+
+			ast.Inspect(n.Type, visit)
+			if n.Body != nil {
+				ast.Inspect(n.Body, visit)
+			}
+			fdecl = nil
+			return false
+		case *ast.CompositeLit:
+			ctx.visitCompositeLit(n, compositeLitTypStack, visit)
+			return false
+		case *ast.Ident:
+			startPos = n.NamePos
+			e = n
+		case *ast.SelectorExpr:
+			startPos = n.Sel.NamePos
+			e = n
+		default:
+			return true
+		}
+
+		start := ctx.fset.Position(startPos).Offset
+		end := start + int(n.End()-startPos)
+		found = start <= searchpos && searchpos <= end
+
+		return !found
+	}
+	ast.Inspect(f, visit)
+
+	if !found {
+		e = nil
+		fdecl = nil
+		err = NoIdentifier
+	}
+
+	return
+}
+
+// findTypeSwitch returns the innermost type switch statement, whose symbol
+// is the given identifier, or nil if there isn't one.
+func (ctx *Context) findTypeSwitch(f *ast.File, identifier ast.Expr, obj *ast.Object) (sw *ast.TypeSwitchStmt) {
+	ident, ok := identifier.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil || n.Pos() > ident.Pos() || ident.End() > n.End() {
+			return false
+		}
+
+		if s, ok := n.(*ast.TypeSwitchStmt); ok && ctx.isTypeSwitchSymbol(s, ident, obj) {
+			sw = s
+		}
+		return true
+	})
+
+	return
+}
+
+// funcName gives the name of function declaration, like "Func",
+// "T.Method" or "(*T).Method", empty if n is not a function declaration
+func funcName(n ast.Node) string {
+	fdecl, ok := n.(*ast.FuncDecl)
+	if !ok {
+		return ""
+	}
+
+	if fdecl.Recv == nil || len(fdecl.Recv.List) != 1 {
+		return fdecl.Name.Name
+	}
+
+	recv := fdecl.Recv.List[0].Type
+	if isPointer(recv) {
+		return fmt.Sprintf("(*%s).%s", typNodeName(recv), fdecl.Name.Name)
+	}
+	return fmt.Sprintf("%s.%s", typNodeName(recv), fdecl.Name.Name)
+}
+
+// importsOf gives the import specs of f, the parser doesn't fill
+// f.Imports.
+func importsOf(f *ast.File) []*ast.ImportSpec {
+	var specs []*ast.ImportSpec
+	for _, decl := range f.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range d.Specs {
+			if spec, ok := spec.(*ast.ImportSpec); ok {
+				specs = append(specs, spec)
+			}
+		}
+	}
+	return specs
+}
+
+func isDotImport(spec *ast.ImportSpec) bool {
+	return spec.Name != nil && spec.Name.Name == "."
+}
+
+// dotImportedSelector makes a selector qualified by the package of spec,
+// for identifier n, which is imported to ".".
+func dotImportedSelector(n *ast.Ident, spec *ast.ImportSpec) *ast.SelectorExpr {
+	obj := ast.NewObj(ast.Pkg, spec.Name.Name)
+	obj.Decl = spec
+
+	x := &ast.Ident{NamePos: n.NamePos, Name: spec.Name.Name, Obj: obj}
+	return &ast.SelectorExpr{X: x, Sel: n}
+}
+
+func (ctx *Context) visitCompositeLit(n *ast.CompositeLit, compositeLitTypStack *list.List, visit func(n ast.Node) bool) {
+	if n.Type != nil {
+		compositeLitTyp := depointer(n.Type)
+		ast.Inspect(n.Type, visit)
+
+		// Get real type if this is an array composite literal
+		if aryTyp, ok := n.Type.(*ast.ArrayType); ok {
+			compositeLitTyp = depointer(aryTyp.Elt)
+			ctx.debugp("composite literal is an array")
+		}
+
+		listElt := compositeLitTypStack.PushFront(compositeLitTyp)
+		defer compositeLitTypStack.Remove(listElt)
+	}
+
+	listElt := compositeLitTypStack.Front()
+	compositeLitTyp := listElt.Value.(ast.Expr)
+
+	ctx.debugp("composite literal type: %T %v", compositeLitTyp, compositeLitTyp)
+	if compositeLitTyp == nil {
+		return
+	}
+
+	ctx.debugp("composite literal, elements len %d", len(n.Elts))
+	for _, element := range n.Elts {
+		if elt, ok := element.(*ast.KeyValueExpr); ok {
+			if key, ok := elt.Key.(*ast.Ident); ok {
+				e := &ast.SelectorExpr{compositeLitTyp, key}
+				ctx.debugp("Inspect %T %v", e, e)
+				ast.Inspect(e, visit)
+
+				ast.Inspect(elt.Value, visit)
+				continue
+			}
+		}
+		ast.Inspect(element, visit)
+	}
+}
+
+func depointer(n ast.Expr) ast.Expr {
+	if v, ok := n.(*ast.StarExpr); ok {
+		return v.X
+	}
+
+	return n
+}
+
+func (ctx *Context) findFieldOuter(field *ast.Field, f *ast.File) (outer *ast.Ident) {
+	fldPos := field.Pos()
+	fldStart := ctx.fset.Position(fldPos).Offset
+	fldEnd := fldStart + int(field.End()-fldPos)
+
+	found := false
+	visit := func(n ast.Node) bool {
+		var (
+			recv   ast.Expr
+			fields *ast.FieldList
+			t      *ast.TypeSpec
+			ok     bool
+		)
+		if found {
+			return false
+		}
+
+		if t, ok = n.(*ast.TypeSpec); ok {
+			// fields of struct, or methods of interface
+			switch typ := t.Type.(type) {
+			case *ast.StructType:
+				recv, fields = typ, typ.Fields
+			case *ast.InterfaceType:
+				recv, fields = typ, typ.Methods
+			default:
+				return true
+			}
+		}
+
+		if recv == nil {
+			return true
+		}
+
+		start := ctx.fset.Position(recv.Pos()).Offset
+		end := start + int(recv.End()-recv.Pos())
+		if start > fldStart || fldEnd > end || fields == nil {
+			return true
+		}
+
+		for _, fld := range fields.List {
+			if fld.Pos() == field.Pos() && fld.End() == field.End() {
+				outer = t.Name
+				found = true
+				break
+			}
+		}
+
+		return !found
+	}
+	ast.Inspect(f, visit)
+
+	return
+}
+
+func (ctx *Context) parseLocalPackage(filename string, src *ast.File, pkgScope *ast.Scope) (*ast.Package, error) {
+	pkg := &ast.Package{Name: ctx.pkgNameOfFile(filename), Scope: pkgScope, Files: map[string]*ast.File{filename: src}}
+	d := filepath.Dir(filename)
+	list, err := getFileNames(false, d, ctx.Overlay)
+	if err != nil {
+		return nil, errorGenerator("read dir %s failed, %v", d, err)
+	}
+
+	for _, file := range list {
+		if file == filepath.Clean(filename) ||
+			!ctx.matchFile(file) ||
+			ctx.pkgNameOfFile(file) != pkg.Name {
+			continue
+		}
+		src, err := parser.ParseFile(ctx.fset, file, ctx.source(file), 0, pkg.Scope)
+		if err == nil {
+			ctx.rewriteVendorImports(src, d)
+			pkg.Files[file] = src
+		}
+	}
+	if len(pkg.Files) == 1 {
+		return nil, NoMorePkgFiles
+	}
+	return pkg, nil
+}
@@ -0,0 +1,323 @@
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/token"
+	"code.google.com/p/rog-go/exp/go/types"
+	"fmt"
+	"sync"
+)
+
+// implSub matches type declarations instead of references. If the subject
+// is an interface, concrete types satisfying it are matched, otherwise
+// interfaces satisfied by the subject are matched.
+type implSub struct {
+	debugger
+	*loader
+
+	self *ast.Ident
+
+	typ types.Type
+	obj *ast.Object
+
+	declPos token.Position
+
+	iface bool // subject is an interface
+
+	mutex    sync.Mutex
+	pointers map[token.Pos]bool // types matched only through their pointers, by position
+}
+
+func (ctx *Context) newImplSub(identifier ast.Expr, typ types.Type, obj *ast.Object) (*implSub, error) {
+	var self *ast.Ident
+	switch t := identifier.(type) {
+	case *ast.Ident:
+		self = t
+	case *ast.SelectorExpr:
+		self = t.Sel
+	}
+
+	if self == nil || obj == nil || obj.Kind != ast.Typ {
+		return nil, errorGenerator("%v is not a type name", identifier)
+	}
+
+	_, iface := typeSpecOf(typ).(*ast.InterfaceType)
+	return &implSub{debugger: ctx.newDebugger(), loader: ctx.loader,
+		self:     self,
+		typ:      typ,
+		obj:      obj,
+		iface:    iface,
+		pointers: make(map[token.Pos]bool)}, nil
+}
+
+func (subject *implSub) IsMe(e ast.Expr, pkg *ast.Package) bool {
+	n, ok := e.(*ast.Ident)
+	if !ok || !isTypeDeclName(n) {
+		return false
+	}
+
+	typ := subject.typeOf(n, pkg)
+	if typ.Kind == ast.Bad {
+		return false
+	}
+	subject.regainPkgName(&typ, n.Pos())
+	subject.debugp("implSub.IsMe() matching type %v", typ)
+
+	_, iface := typeSpecOf(typ).(*ast.InterfaceType)
+	if subject.iface {
+		return !iface && subject.satisfies(n, typ, subject.typ)
+	}
+
+	return iface && subject.satisfies(n, subject.typ, typ)
+}
+
+// satisfies reports whether either typ or *typ satisfies interface iface,
+// n is remembered if only *typ does
+func (subject *implSub) satisfies(n *ast.Ident, typ types.Type, iface types.Type) bool {
+	if subject.implements(typ, iface, false) {
+		return true
+	}
+	if !subject.implements(typ, iface, true) {
+		return false
+	}
+
+	subject.mutex.Lock()
+	subject.pointers[n.Pos()] = true
+	subject.mutex.Unlock()
+	return true
+}
+
+// byPointer reports whether type declaration n is matched only through its
+// pointer
+func (subject *implSub) byPointer(n ast.Expr) bool {
+	subject.mutex.Lock()
+	defer subject.mutex.Unlock()
+
+	return subject.pointers[n.Pos()]
+}
+
+func (subject *implSub) DeclPos() token.Pos {
+	return types.DeclPos(subject.obj)
+}
+
+func (subject *implSub) DeclEnd() token.Pos {
+	return identEnd(subject.DeclPos(), subject.obj.Name)
+}
+
+func (subject *implSub) Toast() {
+	subject.regainPkgName(&subject.typ, subject.DeclPos())
+	subject.declPos = subject.fset.Position(subject.DeclPos())
+}
+
+func (subject *implSub) Name() string {
+	return subject.self.Name
+}
+
+func (subject *implSub) Kind() string {
+	return subject.obj.Kind.String()
+}
+
+func (subject *implSub) String() string {
+	s := fmt.Sprintf("implSub, self %v", subject.self)
+	s += fmt.Sprintf(" typ: %v", subject.typ)
+	s += fmt.Sprintf(" obj: %v", subject.obj)
+	s += fmt.Sprintf(" interface: %v", subject.iface)
+	s += fmt.Sprintf(" decl pos: %v", subject.declPos)
+
+	return s
+}
+
+// isTypeDeclName reports whether n is the name of a type declaration
+func isTypeDeclName(n *ast.Ident) bool {
+	if n.Obj == nil || n.Obj.Kind != ast.Typ {
+		return false
+	}
+
+	spec, ok := n.Obj.Decl.(*ast.TypeSpec)
+	return ok && spec.Name == n
+}
+
+// implements reports whether the method set of typ, or of *typ if ptr is
+// true, satisfies interface iface. Methods are compared by name, parameter
+// and result types. Empty interfaces are never reported, everything
+// satisfies them.
+func (l *loader) implements(typ types.Type, iface types.Type, ptr bool) bool {
+	methods := make(map[string]*ast.FuncType)
+	l.interfaceMethods(iface, methods, make(map[string]bool))
+	if len(methods) == 0 {
+		return false
+	}
+
+	for name, ft := range methods {
+		if !l.inMethodSet(typ, name, ptr) {
+			return false
+		}
+
+		obj := typ.Member(name)
+		if obj == nil || !l.sameSignature(funcTypeOf(obj), ft) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// interfaceMethods collects methods of iface, including the ones of its
+// embedded interfaces.
+func (l *loader) interfaceMethods(iface types.Type, methods map[string]*ast.FuncType, visited map[string]bool) {
+	key := iface.Pkg + "." + typNodeName(iface.Node)
+	if visited[key] {
+		return
+	}
+	visited[key] = true
+
+	t, ok := typeSpecOf(iface).(*ast.InterfaceType)
+	if !ok {
+		return
+	}
+
+	for _, m := range t.Methods.List {
+		ft, ok := m.Type.(*ast.FuncType)
+		if !ok {
+			continue
+		}
+		for _, n := range m.Names {
+			methods[n.Name] = ft
+		}
+	}
+
+	for _, embedded := range l.embeddedTypes(iface) {
+		l.interfaceMethods(embedded, methods, visited)
+	}
+}
+
+func funcTypeOf(obj *ast.Object) *ast.FuncType {
+	switch d := obj.Decl.(type) {
+	case *ast.FuncDecl:
+		return d.Type
+	case *ast.Field:
+		ft, _ := d.Type.(*ast.FuncType)
+		return ft
+	}
+
+	return nil
+}
+
+// sameSignature reports whether f1 and f2 have identical parameter and
+// result types. Type names are compared by their declarations, the other
+// types part by part.
+func (l *loader) sameSignature(f1, f2 *ast.FuncType) bool {
+	if f1 == nil || f2 == nil {
+		return false
+	}
+
+	return l.sameFieldTypes(f1.Params, f2.Params) &&
+		l.sameFieldTypes(f1.Results, f2.Results)
+}
+
+func (l *loader) sameFieldTypes(fields1, fields2 *ast.FieldList) bool {
+	typs1, typs2 := fieldTypes(fields1), fieldTypes(fields2)
+	if len(typs1) != len(typs2) {
+		return false
+	}
+
+	for i := range typs1 {
+		if !l.sameTypeExpr(typs1[i], typs2[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldTypes gives the type of every field, once for each of its names
+func fieldTypes(fields *ast.FieldList) (typs []ast.Expr) {
+	if fields == nil {
+		return nil
+	}
+
+	for _, fld := range fields.List {
+		n := len(fld.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			typs = append(typs, fld.Type)
+		}
+	}
+
+	return
+}
+
+// sameTypeExpr reports whether type expressions e1 and e2 denote the same
+// type. Lengths of arrays are not evaluated, struct and interface literals
+// are not compared by their members.
+func (l *loader) sameTypeExpr(e1, e2 ast.Expr) bool {
+	if p, ok := e1.(*ast.ParenExpr); ok {
+		return l.sameTypeExpr(p.X, e2)
+	}
+	if p, ok := e2.(*ast.ParenExpr); ok {
+		return l.sameTypeExpr(e1, p.X)
+	}
+
+	switch t1 := e1.(type) {
+	case *ast.Ident, *ast.SelectorExpr:
+		return l.sameTypeName(e1, e2)
+	case *ast.StarExpr:
+		t2, ok := e2.(*ast.StarExpr)
+		return ok && l.sameTypeExpr(t1.X, t2.X)
+	case *ast.Ellipsis:
+		t2, ok := e2.(*ast.Ellipsis)
+		return ok && l.sameTypeExpr(t1.Elt, t2.Elt)
+	case *ast.ArrayType:
+		t2, ok := e2.(*ast.ArrayType)
+		return ok && (t1.Len == nil) == (t2.Len == nil) && l.sameTypeExpr(t1.Elt, t2.Elt)
+	case *ast.MapType:
+		t2, ok := e2.(*ast.MapType)
+		return ok && l.sameTypeExpr(t1.Key, t2.Key) && l.sameTypeExpr(t1.Value, t2.Value)
+	case *ast.ChanType:
+		t2, ok := e2.(*ast.ChanType)
+		return ok && t1.Dir == t2.Dir && l.sameTypeExpr(t1.Value, t2.Value)
+	case *ast.FuncType:
+		t2, ok := e2.(*ast.FuncType)
+		return ok && l.sameSignature(t1, t2)
+	case *ast.StructType:
+		_, ok := e2.(*ast.StructType)
+		return ok
+	case *ast.InterfaceType:
+		_, ok := e2.(*ast.InterfaceType)
+		return ok
+	}
+
+	return false
+}
+
+// sameTypeName reports whether type names e1 and e2 are declared by the
+// same declaration. Names which can't be resolved are compared as they are.
+func (l *loader) sameTypeName(e1, e2 ast.Expr) bool {
+	switch e2.(type) {
+	case *ast.Ident, *ast.SelectorExpr:
+	default:
+		return false
+	}
+
+	t1, t2 := l.typeOf(e1, nil), l.typeOf(e2, nil)
+	if t1.Kind == ast.Bad || t2.Kind == ast.Bad {
+		return t1.Kind == t2.Kind && selectedName(e1) == selectedName(e2)
+	}
+
+	l.regainPkgName(&t1, t1.Node.Pos())
+	l.regainPkgName(&t2, t2.Node.Pos())
+	return sameDeclType(t1, t2)
+}
+
+// selectedName gives the name of identifier, or the selected one of a
+// qualified identifier.
+func selectedName(e ast.Expr) string {
+	switch e := e.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/parser"
+	"code.google.com/p/rog-go/exp/go/token"
+	"path/filepath"
+	"strconv"
+)
+
+// Search scopes
+const (
+	ScopePath      = "path"      // every file in search path
+	ScopeImporters = "importers" // packages in search path importing the subject's, transitively
+)
+
+// rootOf gives the root searched for importers of the package in declDir,
+// if the search path is not given. It's the directory of go.mod of main
+// module, or the root of GOPATH tree containing declDir, empty if there
+// isn't one.
+func (l *loader) rootOf(declDir string) string {
+	if l.module != nil {
+		return l.module.Dir
+	}
+
+	return l.gopathRoot(declDir)
+}
+
+// importers gives the files of packages which import the package in
+// declDir, directly or not, and the package itself. Packages are found by
+// import clauses of filenames, only the ones among filenames are given.
+func (ctx *Context) importers(filenames []string, declDir string) []string {
+	imports := make([][]string, len(filenames))
+	ctx.parallel(len(filenames), func(i int) {
+		src, err := ctx.ReadFile(filenames[i])
+		if err != nil {
+			return
+		}
+
+		f, _ := parser.ParseFile(token.NewFileSet(), filenames[i], src, parser.ImportsOnly, nil)
+		if f == nil {
+			return
+		}
+		ctx.rewriteVendorImports(f, filepath.Dir(filenames[i]))
+		for _, spec := range importsOf(f) {
+			if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+				imports[i] = append(imports[i], path)
+			}
+		}
+	})
+
+	// packages importing each import path, by directory
+	importedBy := make(map[string]map[string]bool)
+	for i, filename := range filenames {
+		dir := filepath.Dir(filename)
+		for _, path := range imports[i] {
+			if importedBy[path] == nil {
+				importedBy[path] = make(map[string]bool)
+			}
+			importedBy[path][dir] = true
+		}
+	}
+
+	dirs := map[string]bool{declDir: true}
+	queue := []string{declDir}
+	for len(queue) != 0 {
+		dir := queue[0]
+		queue = queue[1:]
+
+		path := ctx.dirImportPath(dir)
+		if path == "" {
+			// can't be imported
+			continue
+		}
+		for importer := range importedBy[path] {
+			if !dirs[importer] {
+				dirs[importer] = true
+				queue = append(queue, importer)
+			}
+		}
+	}
+	ctx.debugp("Importers of %s: %v", declDir, dirs)
+
+	var selected []string
+	for _, filename := range filenames {
+		if dirs[filepath.Dir(filename)] {
+			selected = append(selected, filename)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,379 @@
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/parser"
+	"code.google.com/p/rog-go/exp/go/token"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// index caches references found in the files under one search path, so
+// repeated queries only scan the files changed since the last time.
+//
+// References found in a file are recorded per subject, the entry of a file
+// is dropped once its modification time and contents change. A subject is
+// identified by its declaration position and the hash of its declaring file,
+// so changes of the declaration make a new subject. References of a file
+// are recorded along with the hash of the packages it depends on, they're
+// found again once any of these packages changes. Subjects whose declaring
+// file has changed, or is gone, are dropped from the entry of a file once
+// it's scanned again.
+type index struct {
+	Version int                   // format of index, older ones are dropped
+	Path    string                // search path the index is built for
+	Files   map[string]*fileEntry // entries by file name
+
+	filename string // where the index is stored
+}
+
+type fileEntry struct {
+	ModTime int64    // modification time in nanoseconds, 0 for modified files
+	Size    int64    // size of file
+	Hash    string   // SHA-1 of contents
+	Imports []string // import paths, vendored ones are resolved
+	Refs    map[string]*cachedRefs
+}
+
+// cachedRefs are the references of one subject found in a file
+type cachedRefs struct {
+	Deps     string // hash of the packages the file depends on, see deps
+	Decl     string // declaring file of the subject, empty if it has none
+	DeclHash string // SHA-1 of the declaring file
+	Refs     []Reference
+}
+
+// indexVersion is increased once the format of index changes
+const indexVersion = 4
+
+// DefaultIndexDir returns the directory indexes are stored in by default,
+// $XDG_CACHE_HOME/goref, or $HOME/.cache/goref.
+func DefaultIndexDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "goref")
+	}
+
+	return filepath.Join(os.Getenv("HOME"), ".cache", "goref")
+}
+
+// loadIndex reads the index of path stored in dir, an empty index is given
+// if there isn't one, or it's broken.
+func loadIndex(dir, path string) *index {
+	sum := sha1.Sum([]byte(path))
+	idx := &index{
+		Version:  indexVersion,
+		Path:     path,
+		Files:    make(map[string]*fileEntry),
+		filename: filepath.Join(dir, hex.EncodeToString(sum[:])+".json"),
+	}
+
+	data, err := ioutil.ReadFile(idx.filename)
+	if err != nil {
+		return idx
+	}
+
+	saved := &index{}
+	if err = json.Unmarshal(data, saved); err != nil || saved.Version != indexVersion ||
+		saved.Path != path || saved.Files == nil {
+		return idx
+	}
+	idx.Files = saved.Files
+	return idx
+}
+
+// save writes the index back to its file, the old one is replaced only
+// when the new one is completely written.
+func (idx *index) save() error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(idx.filename)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(idx.filename))
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), idx.filename)
+}
+
+// entry returns the up to date entry of filename, the stale one is replaced
+// by an empty entry.
+func (idx *index) entry(ctx *Context, filename string) (*fileEntry, error) {
+	old := idx.Files[filename]
+
+	var modTime, size int64
+	if _, ok := ctx.Overlay[filename]; !ok {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		modTime, size = info.ModTime().UnixNano(), info.Size()
+		if old != nil && old.ModTime == modTime && old.Size == size {
+			return old, nil
+		}
+	}
+
+	src, err := ctx.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	hash := hashOf(src)
+	if old != nil && old.Hash == hash {
+		// touched, or modified but not changed
+		old.ModTime, old.Size = modTime, size
+		return old, nil
+	}
+
+	e := &fileEntry{ModTime: modTime, Size: size, Hash: hash, Refs: make(map[string]*cachedRefs)}
+	f, _ := parser.ParseFile(token.NewFileSet(), filename, src, parser.ImportsOnly, nil)
+	if f != nil {
+		ctx.rewriteVendorImports(f, filepath.Dir(filename))
+		for _, spec := range importsOf(f) {
+			if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+				e.Imports = append(e.Imports, path)
+			}
+		}
+	}
+	idx.Files[filename] = e
+	return e, nil
+}
+
+// forget drops the entries of files which don't exist any more, the ones
+// out of the search of this time are kept for later queries.
+func (idx *index) forget(ctx *Context) {
+	for filename := range idx.Files {
+		if _, ok := ctx.Overlay[filename]; ok {
+			continue
+		}
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			delete(idx.Files, filename)
+		}
+	}
+}
+
+// scan finds references of the subject of ctx in filenames, all is every
+// file in the search path, filenames are the ones might refer the subject.
+// Files having up to date references in index are not scanned, their
+// references are returned. Only the packages of the others are parsed,
+// references in them are given to ctx.RefPrinter, and recorded in index.
+func (idx *index) scan(ctx *Context, all, filenames []string) ([]Reference, error) {
+	key, err := idx.subjectKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*fileEntry)
+	for _, filename := range all {
+		e, err := idx.entry(ctx, filename)
+		if err != nil {
+			return nil, err
+		}
+		entries[filename] = e
+	}
+	idx.forget(ctx)
+
+	declDir := ""
+	if position := ctx.fset.Position(ctx.Subject.DeclPos()); position.IsValid() {
+		declDir = filepath.Dir(position.Filename)
+	}
+	deps := newDeps(ctx, entries, declDir)
+
+	// hashes of declaring files, empty for the ones gone
+	declHashes := make(map[string]string)
+	declHash := func(filename string) string {
+		if hash, ok := declHashes[filename]; ok {
+			return hash
+		}
+		hash := ""
+		if e, ok := entries[filename]; ok {
+			hash = e.Hash
+		} else if src, err := ctx.ReadFile(filename); err == nil {
+			hash = hashOf(src)
+		}
+		declHashes[filename] = hash
+		return hash
+	}
+	decl := ctx.fset.Position(ctx.Subject.DeclPos()).Filename
+
+	var cached []Reference
+	stale := make(map[string]*cachedRefs)
+	dirs := make(map[string]bool)
+	uptodate := 0
+	for _, filename := range filenames {
+		if ctx.pruned[filename] {
+			continue
+		}
+
+		e, hash := entries[filename], deps.of(filename)
+		if c, ok := e.Refs[key]; ok && c.Deps == hash {
+			cached = append(cached, c.Refs...)
+			uptodate++
+			continue
+		}
+		e.prune(declHash)
+		c := &cachedRefs{Deps: hash, Refs: []Reference{}}
+		if decl != "" {
+			c.Decl, c.DeclHash = decl, declHash(decl)
+		}
+		e.Refs[key] = c
+		stale[filename] = c
+		dirs[filepath.Dir(filename)] = true
+	}
+
+	ctx.debugp("index: %d files up to date, %d to scan", uptodate, len(stale))
+	if len(stale) == 0 {
+		return cached, nil
+	}
+
+	printer := ctx.RefPrinter
+	ctx.RefPrinter = func(n ast.Expr, fn string, kind string) {
+		printer(n, fn, kind)
+
+		ref := newReference(ctx, n, fn, kind)
+		if c, ok := stale[ref.Start.Filename]; ok {
+			c.Refs = append(c.Refs, ref)
+		}
+	}
+	defer func() { ctx.RefPrinter = printer }()
+
+	// types are resolved in whole packages, the ones without stale
+	// files are not parsed
+	var parsed []string
+	for _, filename := range filenames {
+		if dirs[filepath.Dir(filename)] {
+			parsed = append(parsed, filename)
+		}
+	}
+	err = ctx.scanFiles(parsed, func(filename string) bool {
+		_, ok := stale[filename]
+		return ok
+	})
+	return cached, err
+}
+
+// prune drops references of subjects whose declaring file has changed, or
+// is gone, such subjects can't be asked again.
+func (e *fileEntry) prune(declHash func(filename string) string) {
+	for key, c := range e.Refs {
+		if c.Decl != "" && declHash(c.Decl) != c.DeclHash {
+			delete(e.Refs, key)
+		}
+	}
+}
+
+// deps hashes the packages files depend on: the package of the file, the
+// ones it imports in the search path, directly or not, and the declaring
+// package of the subject. Embedded types, methods and implementations in
+// any of them might change references found in the file.
+type deps struct {
+	entries map[string]*fileEntry
+	files   map[string][]string // file names by directory
+	dirs    map[string]string   // directories by import path
+	hashes  map[string]string   // hashes by directory of file
+	declDir string
+}
+
+func newDeps(ctx *Context, entries map[string]*fileEntry, declDir string) *deps {
+	d := &deps{
+		entries: entries,
+		files:   make(map[string][]string),
+		dirs:    make(map[string]string),
+		hashes:  make(map[string]string),
+		declDir: declDir,
+	}
+	for filename := range entries {
+		dir := filepath.Dir(filename)
+		d.files[dir] = append(d.files[dir], filename)
+	}
+	for dir, names := range d.files {
+		sort.Strings(names)
+		if path := ctx.dirImportPath(dir); path != "" {
+			d.dirs[path] = dir
+		}
+	}
+
+	return d
+}
+
+// of gives the hash of packages filename depends on
+func (d *deps) of(filename string) string {
+	dir := filepath.Dir(filename)
+	if hash, ok := d.hashes[dir]; ok {
+		return hash
+	}
+
+	visited := map[string]bool{d.declDir: true}
+	d.visit(dir, visited)
+	var dirs []string
+	for dir := range visited {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	sum := sha1.New()
+	for _, dir := range dirs {
+		for _, name := range d.files[dir] {
+			fmt.Fprintf(sum, "%s %s\n", name, d.entries[name].Hash)
+		}
+	}
+	hash := hex.EncodeToString(sum.Sum(nil))
+	d.hashes[dir] = hash
+	return hash
+}
+
+func (d *deps) visit(dir string, visited map[string]bool) {
+	visited[dir] = true
+	for _, name := range d.files[dir] {
+		for _, path := range d.entries[name].Imports {
+			if imported, ok := d.dirs[path]; ok && !visited[imported] {
+				d.visit(imported, visited)
+			}
+		}
+	}
+}
+
+// subjectKey identifies the subject of ctx in index, along with the search
+// options which change the references.
+func (idx *index) subjectKey(ctx *Context) (string, error) {
+	position := ctx.fset.Position(ctx.Subject.DeclPos())
+	hash := ""
+	if position.Filename != "" {
+		src, err := ctx.ReadFile(position.Filename)
+		if err != nil {
+			return "", err
+		}
+		hash = hashOf(src)
+	}
+
+	return fmt.Sprintf("%s@%s:%d %s %s dispatch=%v build=%s tests=%v importing=%v",
+		ctx.Subject.Name(), position.Filename, position.Offset, hash, ctx.Mode, ctx.Dispatch,
+		buildConfig(ctx.Build), !ctx.SkipTests, ctx.Importing), nil
+}
+
+func hashOf(src []byte) string {
+	sum := sha1.Sum(src)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package refs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goref-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, indexDir := filepath.Join(dir, "src"), filepath.Join(dir, "index")
+	if err = os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, contents string) {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	find := func(offset int) {
+		q := Query{
+			FileName: filepath.Join(src, "a.go"),
+			Offset:   offset,
+			Path:     src,
+			Jobs:     1,
+			Index:    indexDir,
+		}
+		if _, err := Find(context.Background(), q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// F moves once a.go changes, its old subject can't be asked again
+	write("a.go", "package a\n\nfunc F() {}\n")
+	write("b.go", "package a\n\nfunc g() { F() }\n")
+	find(16)
+	write("a.go", "package a\n\n// F does nothing\nfunc F() {}\n")
+	find(34)
+
+	names, err := filepath.Glob(filepath.Join(indexDir, "*.json"))
+	if err != nil || len(names) != 1 {
+		t.Fatalf("index files %v, %v", names, err)
+	}
+	data, err := ioutil.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := &index{}
+	if err = json.Unmarshal(data, idx); err != nil {
+		t.Fatal(err)
+	}
+
+	e, ok := idx.Files[filepath.Join(src, "b.go")]
+	if !ok {
+		t.Fatalf("b.go is not in index %v", idx.Files)
+	}
+	if len(e.Refs) != 1 {
+		t.Errorf("%d subjects of b.go, want 1: %v", len(e.Refs), e.Refs)
+	}
+}
@@ -0,0 +1,141 @@
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/token"
+)
+
+// Kinds of references
+const (
+	KindDecl   = "decl"   // declaration
+	KindWrite  = "write"  // assigned, incremented, decremented, or address taken
+	KindRead   = "read"   // any other use of value
+	KindCall   = "call"   // called function or method
+	KindKey    = "key"    // field name as key of composite literal
+	KindType   = "type"   // used as type
+	KindImport = "import" // name of import
+
+	// type declaration satisfying the interface, or the interface satisfied
+	// by the subject, only through the pointer of type, in implements mode
+	KindPointer = "pointer"
+)
+
+func isKind(kind string) bool {
+	switch kind {
+	case KindDecl, KindWrite, KindRead, KindCall, KindKey, KindType, KindImport, KindPointer:
+		return true
+	}
+	return false
+}
+
+// useKinds gives kinds of expressions found by the way they're used.
+// Expressions not in it are read.
+type useKinds map[ast.Node]string
+
+// newUseKinds finds the kinds of expressions in n, by their parents
+func newUseKinds(n ast.Node) useKinds {
+	uses := make(useKinds)
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range n.Lhs {
+				id, ok := lhs.(*ast.Ident)
+				if n.Tok == token.DEFINE && ok && id.Obj != nil && id.Obj.Decl == n {
+					uses[id] = KindDecl
+					continue
+				}
+				uses.mark(lhs, KindWrite)
+			}
+		case *ast.IncDecStmt:
+			uses.mark(n.X, KindWrite)
+		case *ast.UnaryExpr:
+			if n.Op == token.AND {
+				uses.mark(n.X, KindWrite)
+			}
+		case *ast.RangeStmt:
+			kind := KindWrite
+			if n.Tok == token.DEFINE {
+				kind = KindDecl
+			}
+			for _, e := range []ast.Expr{n.Key, n.Value} {
+				if e != nil {
+					uses.mark(e, kind)
+				}
+			}
+		case *ast.CallExpr:
+			uses.mark(n.Fun, KindCall)
+		case *ast.CompositeLit:
+			for _, element := range n.Elts {
+				if elt, ok := element.(*ast.KeyValueExpr); ok {
+					if key, ok := elt.Key.(*ast.Ident); ok {
+						uses[key] = KindKey
+					}
+				}
+			}
+		case *ast.FuncDecl:
+			uses[n.Name] = KindDecl
+		case *ast.TypeSpec:
+			uses[n.Name] = KindDecl
+		case *ast.ValueSpec:
+			for _, id := range n.Names {
+				uses[id] = KindDecl
+			}
+		case *ast.Field:
+			for _, id := range n.Names {
+				uses[id] = KindDecl
+			}
+		case *ast.LabeledStmt:
+			uses[n.Label] = KindDecl
+		case *ast.ImportSpec:
+			if n.Name != nil {
+				uses[n.Name] = KindImport
+			}
+		}
+		return true
+	})
+
+	return uses
+}
+
+// mark sets kind of e, and the indexed one if e is an index expression
+func (uses useKinds) mark(e ast.Expr, kind string) {
+	for {
+		switch x := e.(type) {
+		case *ast.ParenExpr:
+			e = x.X
+			continue
+		case *ast.IndexExpr:
+			uses[x] = kind
+			e = x.X
+			continue
+		}
+		break
+	}
+	uses[e] = kind
+}
+
+// kindOf gives the kind of reference n to subject. Composite literal keys
+// and dot imported identifiers are made selectors by scan, they're found
+// by their names.
+func (uses useKinds) kindOf(n ast.Expr, subject Subject) string {
+	kind, ok := uses[n]
+	if sel, isSel := n.(*ast.SelectorExpr); !ok && isSel {
+		kind, ok = uses[sel.Sel]
+	}
+
+	switch {
+	case kind == KindDecl:
+		if impl, ok := subject.(*implSub); ok && impl.byPointer(n) {
+			return KindPointer
+		}
+		return kind
+	case kind == KindImport:
+		return kind
+	case subject.Kind() == ast.Typ.String():
+		// conversions are type uses too
+		return KindType
+	case ok:
+		return kind
+	}
+	return KindRead
+}
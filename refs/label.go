@@ -0,0 +1,108 @@
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/token"
+	"fmt"
+)
+
+// labelSub is a label of statement, declared by a labeled statement and
+// used by goto, break and continue statements. Labels are scoped to the
+// body of the enclosing function, where they're used.
+type labelSub struct {
+	debugger
+	*loader
+
+	self *ast.Ident
+	decl *ast.Ident // nil if the label is not declared
+
+	fn   ast.Node           // enclosing *ast.FuncDecl or *ast.FuncLit
+	uses map[token.Pos]bool // positions of the label declaration and uses
+}
+
+// findLabel returns the label subject if identifier is the label of a
+// labeled, goto, break or continue statement in f, nil otherwise.
+func (ctx *Context) findLabel(f *ast.File, identifier ast.Expr) *labelSub {
+	ident, ok := identifier.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+
+	// the innermost function, and whether ident is a label in it
+	var fn ast.Node
+	isLabel := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil || n.Pos() > ident.Pos() || ident.End() > n.End() {
+			return false
+		}
+
+		switch n := n.(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+			fn = n
+		case *ast.LabeledStmt:
+			isLabel = isLabel || n.Label == ident
+		case *ast.BranchStmt:
+			isLabel = isLabel || n.Label == ident
+		}
+		return true
+	})
+	if !isLabel || fn == nil {
+		return nil
+	}
+
+	subject := &labelSub{debugger: ctx.newDebugger(), loader: ctx.loader, self: ident, fn: fn, uses: make(map[token.Pos]bool)}
+	ast.Inspect(fn, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			// labels of function literals are their own
+			return n == fn
+		case *ast.LabeledStmt:
+			if n.Label.Name == ident.Name {
+				subject.decl = n.Label
+				subject.uses[n.Label.Pos()] = true
+			}
+		case *ast.BranchStmt:
+			if n.Label != nil && n.Label.Name == ident.Name {
+				subject.uses[n.Label.Pos()] = true
+			}
+		}
+		return true
+	})
+
+	return subject
+}
+
+func (subject *labelSub) IsMe(e ast.Expr, pkg *ast.Package) bool {
+	ident, ok := e.(*ast.Ident)
+	return ok && ident.Name == subject.self.Name && subject.uses[ident.Pos()]
+}
+
+func (subject *labelSub) DeclPos() token.Pos {
+	if subject.decl == nil {
+		return subject.self.Pos()
+	}
+
+	return subject.decl.Pos()
+}
+
+func (subject *labelSub) DeclEnd() token.Pos {
+	if subject.decl == nil {
+		return subject.self.End()
+	}
+
+	return subject.decl.End()
+}
+
+func (subject *labelSub) Toast() {}
+
+func (subject *labelSub) Name() string {
+	return subject.self.Name
+}
+
+func (subject *labelSub) Kind() string {
+	return "label"
+}
+
+func (subject *labelSub) String() string {
+	return fmt.Sprintf("labelSub, self %v, decl %v, %d uses", subject.self, subject.decl, len(subject.uses))
+}
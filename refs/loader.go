@@ -0,0 +1,378 @@
+package refs
+
+import (
+	"bytes"
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/parser"
+	"code.google.com/p/rog-go/exp/go/token"
+	"code.google.com/p/rog-go/exp/go/types"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// loader loads packages imported by a search, and keeps what it has found
+// about them. Every search has its own, nothing is shared by concurrent
+// searches.
+type loader struct {
+	fset    *token.FileSet    // files of searched and imported packages
+	gopath  []string          // roots of source trees, like $GOPATH/src
+	build   *build.Context    // files of imported packages are selected by it
+	overlay map[string][]byte // contents of modified files by file name
+	cache   *Cache            // imported packages kept for other searches, if any
+
+	// main module of search, imports are resolved by its go.mod, then in
+	// gopath. It's nil in GOPATH mode.
+	module *module
+
+	mutex   sync.Mutex                    // serializes importing, guards the following ones
+	modules map[string]*module            // modules by directory, nil if none
+	imports map[string]*ast.Package       // imported packages by import path
+	tested  map[*ast.Package]testedImport // packages under test, by their external test packages
+}
+
+// testedImport is the package under test, with its _test.go files, which
+// is imported by the external test package instead of the one without tests.
+type testedImport struct {
+	path string
+	pkg  *ast.Package
+}
+
+// newLoader gives the loader of search in dir, packages are looked up in
+// gopath, or the default one if it's empty. Files are selected by bctx, and
+// read from overlay if they're modified. Imported packages are kept by
+// cache, if it's not nil, and taken from it.
+func newLoader(dir string, gopath []string, bctx *build.Context, overlay map[string][]byte, cache *Cache) *loader {
+	if len(gopath) == 0 {
+		gopath = defaultGoPath()
+	}
+	fset := token.NewFileSet()
+	if cache != nil {
+		fset = cache.fileSet()
+	}
+	l := &loader{
+		fset:    fset,
+		gopath:  gopath,
+		build:   bctx,
+		overlay: overlay,
+		cache:   cache,
+		modules: make(map[string]*module),
+		imports: make(map[string]*ast.Package),
+		tested:  make(map[*ast.Package]testedImport),
+	}
+	l.module = l.findModule(dir)
+
+	return l
+}
+
+// readFile reads the content of file, from the overlay if it's modified,
+// or from disk.
+func (l *loader) readFile(filename string) ([]byte, error) {
+	if src, ok := l.overlay[filename]; ok {
+		return src, nil
+	}
+
+	return ioutil.ReadFile(filename)
+}
+
+// source gives the source argument of parser.ParseFile(), nil means the
+// file is read from disk by parser itself.
+func (l *loader) source(filename string) interface{} {
+	if src, ok := l.overlay[filename]; ok {
+		return src
+	}
+
+	return nil
+}
+
+// importer works like types.DefaultImporter, except it's safe to be called
+// concurrently, packages are found by the go.mod of main module first,
+// parsed into l.fset, and read from the overlay.
+func (l *loader) importer(path string) *ast.Package {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if pkg, ok := l.imports[path]; ok {
+		return pkg
+	}
+
+	var pkg *ast.Package
+	if dir := l.module.dirOf(path); dir != "" {
+		pkg = l.parsePackage(dir, l.moduleFiles(dir))
+	} else if dir, filenames := l.gopathFiles(path); dir != "" {
+		pkg = l.parsePackage(dir, filenames)
+	}
+	l.imports[path] = pkg
+	return pkg
+}
+
+// moduleFiles gives files of the package in dir, found by go.mod, tests are
+// not counted.
+func (l *loader) moduleFiles(dir string) []string {
+	filenames, err := getFileNames(false, dir, l.overlay)
+	if err != nil {
+		return nil
+	}
+
+	var selected []string
+	for _, filename := range filenames {
+		if !isTestFile(filename) && matchFile(l.build, l.overlay, filename) {
+			selected = append(selected, filename)
+		}
+	}
+	return selected
+}
+
+// gopathFiles finds the package of path in l.gopath, gives its directory
+// and files, empty if it's not found.
+func (l *loader) gopathFiles(path string) (string, []string) {
+	bctx := build.Default
+	if l.build != nil {
+		bctx = *l.build
+	}
+	bctx.OpenFile = func(filename string) (io.ReadCloser, error) {
+		src, err := l.readFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(src)), nil
+	}
+
+	for _, root := range l.gopath {
+		bpkg, err := bctx.ImportDir(filepath.Join(root, filepath.FromSlash(path)), 0)
+		if err != nil {
+			continue
+		}
+
+		var filenames []string
+		for _, name := range append(bpkg.GoFiles, bpkg.CgoFiles...) {
+			filenames = append(filenames, filepath.Join(bpkg.Dir, name))
+		}
+		return bpkg.Dir, filenames
+	}
+
+	return "", nil
+}
+
+// defaultGoPath gives source roots of $GOPATH, $HOME/go if it's not set,
+// and of GOROOT.
+func defaultGoPath() []string {
+	p := os.Getenv("GOPATH")
+	if p == "" {
+		p = filepath.Join(os.Getenv("HOME"), "go")
+	}
+
+	var gopath []string
+	for _, d := range filepath.SplitList(p) {
+		gopath = append(gopath, filepath.Join(d, "src"))
+	}
+	if r := runtime.GOROOT(); r != "" {
+		// standard packages are in src since Go 1.4, src/pkg before
+		gopath = append(gopath, filepath.Join(r, "src"), filepath.Join(r, "src", "pkg"))
+	}
+	return gopath
+}
+
+// parsePackage parses filenames of the package in dir, nil if none of them
+// is parsed. l.mutex must be held.
+func (l *loader) parsePackage(dir string, filenames []string) *ast.Package {
+	// files are selected, and imports are rewritten, by the way of search
+	key := fmt.Sprintf("%s %v %s %s", buildConfig(l.build), l.module == nil, strings.Join(l.gopath, string(filepath.ListSeparator)), dir)
+	if pkg := l.cache.lookup(key, filenames, l.overlay); pkg != nil {
+		return pkg
+	}
+
+	var pkg *ast.Package
+	for _, filename := range filenames {
+		if pkg == nil {
+			pkg = &ast.Package{Scope: ast.NewScope(parser.Universe), Files: make(map[string]*ast.File)}
+		}
+		f, _ := parser.ParseFile(l.fset, filename, l.source(filename), 0, pkg.Scope)
+		if f == nil {
+			continue
+		}
+		if pkg.Name == "" {
+			pkg.Name = f.Name.Name
+		}
+		if l.module == nil {
+			// imports of GOPATH packages are looked up in their
+			// vendor directories too
+			l.vendorImports(f, dir)
+		}
+		pkg.Files[filename] = f
+	}
+
+	l.cache.store(key, pkg, filenames, l.overlay)
+	return pkg
+}
+
+// importerOf gives the importer of files of pkg. The package under test is
+// imported with its _test.go files by the external test package, but not by
+// the others.
+func (l *loader) importerOf(pkg *ast.Package) types.Importer {
+	l.mutex.Lock()
+	t, ok := l.tested[pkg]
+	l.mutex.Unlock()
+	if !ok {
+		return l.importer
+	}
+
+	return func(path string) *ast.Package {
+		if path == t.path {
+			return t.pkg
+		}
+		return l.importer(path)
+	}
+}
+
+// exprType works like types.ExprType, for expression n in pkg, which might
+// be nil if it's unknown
+func (l *loader) exprType(n ast.Expr, pkg *ast.Package) (*ast.Object, types.Type) {
+	return types.ExprType(n, l.importerOf(pkg))
+}
+
+// typeOf gives the type of expression n in pkg, a bad one if it's unknown
+func (l *loader) typeOf(n ast.Expr, pkg *ast.Package) types.Type {
+	_, t := l.exprType(n, pkg)
+	if t.Kind == ast.Bad || t.Node == nil {
+		return types.Type{Kind: ast.Bad}
+	}
+
+	return t
+}
+
+// findModule finds go.mod in dir, or its nearest parent, nil if there
+// isn't one.
+func (l *loader) findModule(dir string) *module {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var visited []string
+	var m *module
+	for {
+		if cached, ok := l.modules[dir]; ok {
+			m = cached
+			break
+		}
+		visited = append(visited, dir)
+
+		if data, err := l.readFile(filepath.Join(dir, "go.mod")); err == nil {
+			m = parseGoMod(dir, data)
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	for _, d := range visited {
+		l.modules[d] = m
+	}
+	return m
+}
+
+// importPathOf gives import path of the package in dir, by the go.mod of
+// main module, or the module enclosing dir. Empty if it's not in a module.
+func (l *loader) importPathOf(dir string) string {
+	if m := l.module; m != nil {
+		vendor := filepath.Join(m.Dir, "vendor") + string(filepath.Separator)
+		if strings.HasPrefix(dir, vendor) {
+			return filepath.ToSlash(strings.TrimPrefix(dir, vendor))
+		}
+		for mod, replaced := range m.Replace {
+			if rest, ok := trimDir(dir, replaced); ok {
+				return mod + rest
+			}
+		}
+	}
+
+	if m := l.findModule(dir); m != nil {
+		if rest, ok := trimDir(dir, m.Dir); ok {
+			return m.Path + rest
+		}
+	}
+
+	// module cache keeps modules as escaped/path@version
+	cache := moduleCache() + string(filepath.Separator)
+	if rel := strings.TrimPrefix(dir, cache); rel != dir {
+		rel = filepath.ToSlash(rel)
+		if i := strings.Index(rel, "@"); i >= 0 {
+			rest := ""
+			if j := strings.Index(rel[i:], "/"); j >= 0 {
+				rest = rel[i+j:]
+			}
+			return unescapeModulePath(rel[:i]) + rest
+		}
+	}
+
+	return ""
+}
+
+// dirImportPath gives import path of the package in dir, by go.mod, or
+// l.gopath. Empty if it's not found.
+func (l *loader) dirImportPath(dir string) string {
+	if path := l.importPathOf(dir); path != "" {
+		return path
+	}
+
+	// vendored copies are named by their path in GOPATH, like "a/vendor/x"
+	root := l.gopathRoot(dir)
+	if root == "" || root == dir {
+		return ""
+	}
+	return filepath.ToSlash(strings.TrimPrefix(dir, root+string(filepath.Separator)))
+}
+
+// pkgNameOfFile gives the name of package which filename belongs to. It's
+// the import path of package, if the package is named after its directory.
+func (l *loader) pkgNameOfFile(filename string) string {
+	prog, _ := parser.ParseFile(token.NewFileSet(), filename, l.source(filename), parser.PackageClauseOnly, nil)
+	if prog == nil {
+		return ""
+	}
+
+	dir := filepath.Dir(filename)
+	if filepath.Base(dir) != prog.Name.Name {
+		return prog.Name.Name
+	}
+
+	return l.dirImportPath(dir)
+}
+
+func (l *loader) pkgNameOfPos(pos token.Pos) string {
+	if pos == token.NoPos {
+		return ""
+	}
+
+	position := l.fset.Position(pos)
+	if !position.IsValid() {
+		return ""
+	}
+
+	return l.pkgNameOfFile(position.Filename)
+}
+
+func (l *loader) regainPkgName(t *types.Type, declPos token.Pos) {
+	if (*t).Pkg == "" {
+		(*t).Pkg = l.pkgNameOfPos(declPos)
+	}
+}
+
+// rewriteVendorImports makes imports of file f, in directory dir, refer to
+// the vendored copies of packages, if any, so they're imported and named
+// the way files of vendored copies are. Modules are vendored as the
+// packages themselves, only GOPATH mode needs it.
+func (l *loader) rewriteVendorImports(f *ast.File, dir string) {
+	if l.module == nil {
+		l.vendorImports(f, dir)
+	}
+}
@@ -0,0 +1,199 @@
+package refs
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// module is a Go module, described by its go.mod
+type module struct {
+	Path    string            // module path
+	Dir     string            // root directory, where go.mod is
+	Require map[string]string // versions of required modules by path
+	Replace map[string]string // local directories of replaced modules by path
+}
+
+func parseGoMod(dir string, data []byte) *module {
+	m := &module{Dir: dir, Require: make(map[string]string), Replace: make(map[string]string)}
+
+	block := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == ")":
+			block = ""
+			continue
+		case block == "" && len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+			continue
+		case block != "":
+			fields = append([]string{block}, fields...)
+		}
+
+		for i := range fields {
+			fields[i] = strings.Trim(fields[i], "\"`")
+		}
+
+		switch fields[0] {
+		case "module":
+			if len(fields) > 1 {
+				m.Path = fields[1]
+			}
+		case "require":
+			if len(fields) > 2 {
+				m.Require[fields[1]] = fields[2]
+			}
+		case "replace":
+			// replace old [version] => new [version], local directories only
+			arrow := -1
+			for i, f := range fields {
+				if f == "=>" {
+					arrow = i
+				}
+			}
+			if arrow < 0 || arrow+1 >= len(fields) {
+				continue
+			}
+			target := fields[arrow+1]
+			if !strings.HasPrefix(target, "./") && !strings.HasPrefix(target, "../") && !filepath.IsAbs(target) {
+				continue
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(dir, target)
+			}
+			m.Replace[fields[1]] = target
+		}
+	}
+
+	if m.Path == "" {
+		return nil
+	}
+	return m
+}
+
+// dirOf gives directory of the package of importPath, empty if it's not
+// found by go.mod. The module itself, local replacements, vendor directory
+// and the module cache are looked up in turn.
+func (m *module) dirOf(importPath string) string {
+	if m == nil {
+		return ""
+	}
+
+	candidates := []string{}
+	if rest, ok := trimModulePath(importPath, m.Path); ok {
+		candidates = append(candidates, filepath.Join(m.Dir, rest))
+	}
+	if mod := longestModule(importPath, m.Replace); mod != "" {
+		rest, _ := trimModulePath(importPath, mod)
+		candidates = append(candidates, filepath.Join(m.Replace[mod], rest))
+	}
+	if _, err := os.Stat(filepath.Join(m.Dir, "vendor", "modules.txt")); err == nil {
+		candidates = append(candidates, filepath.Join(m.Dir, "vendor", filepath.FromSlash(importPath)))
+	}
+	if mod := longestModule(importPath, m.Require); mod != "" {
+		rest, _ := trimModulePath(importPath, mod)
+		candidates = append(candidates, filepath.Join(moduleCache(), escapeModulePath(mod)+"@"+m.Require[mod], rest))
+	}
+
+	for _, dir := range candidates {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+func moduleCache() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" {
+		gopath = filepath.Join(os.Getenv("HOME"), "go")
+	}
+	return filepath.Join(filepath.SplitList(gopath)[0], "pkg", "mod")
+}
+
+// longestModule gives the longest module path in mods which importPath
+// belongs to
+func longestModule(importPath string, mods map[string]string) string {
+	longest := ""
+	for mod := range mods {
+		if _, ok := trimModulePath(importPath, mod); ok && len(mod) > len(longest) {
+			longest = mod
+		}
+	}
+
+	return longest
+}
+
+// trimModulePath gives the rest of importPath in module mod
+func trimModulePath(importPath, mod string) (string, bool) {
+	if importPath == mod {
+		return "", true
+	}
+	if strings.HasPrefix(importPath, mod+"/") {
+		return filepath.FromSlash(strings.TrimPrefix(importPath, mod)), true
+	}
+
+	return "", false
+}
+
+// trimDir gives the rest of dir in root, as slash separated path
+func trimDir(dir, root string) (string, bool) {
+	if dir == root {
+		return "", true
+	}
+	if strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		return filepath.ToSlash(strings.TrimPrefix(dir, root)), true
+	}
+
+	return "", false
+}
+
+// escapeModulePath escapes upper case letters as the module cache does,
+// "!" followed by the lower case letter.
+func escapeModulePath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func unescapeModulePath(path string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range path {
+		switch {
+		case r == '!':
+			upper = true
+			continue
+		case upper:
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package refs
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// TestParallelScan searches with several jobs, which should find the same
+// references in the same order as a single one does. Run it with -race.
+func TestParallelScan(t *testing.T) {
+	for _, q := range []Query{
+		// Draw of Triangle is called through Shape in main/test.go,
+		// shape is imported while files are scanned
+		{FileName: "../tests/pkg/shape/shape.go", Offset: 293, Dispatch: true},
+		// implementations of Shape, every file is scanned
+		{FileName: "../tests/pkg/shape/shape.go", Offset: 99, Mode: ModeImplements},
+	} {
+		q.Path, q.Recurse, q.Jobs = "../tests/pkg", true, 1
+		want, err := Find(context.Background(), q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want.References) < 2 {
+			t.Fatalf("%d references, want at least 2: %v", len(want.References), want.References)
+		}
+
+		q.Jobs = 8
+		for i := 0; i < 4; i++ {
+			result, err := Find(context.Background(), q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.References, want.References) {
+				t.Errorf("with %d jobs, got %v, want %v", q.Jobs, result.References, want.References)
+			}
+			if result.Stats != want.Stats {
+				t.Errorf("with %d jobs, stats %+v, want %+v", q.Jobs, result.Stats, want.Stats)
+			}
+		}
+	}
+}
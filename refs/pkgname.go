@@ -0,0 +1,151 @@
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/token"
+	"code.google.com/p/rog-go/exp/go/types"
+	"fmt"
+	"path"
+	"strconv"
+)
+
+// pkgNameSub is the name of an imported package, as a qualifier of file.
+// References are the import spec and the qualifiers of the importing file,
+// or of every file importing the package if importing is true.
+type pkgNameSub struct {
+	debugger
+	*loader
+
+	spec *ast.ImportSpec // import spec of the subject file
+	path string          // import path
+	name string          // name of package in the subject file
+
+	importing bool
+}
+
+// findImportSpec returns the import spec of f at searchpos, nil if there
+// isn't one.
+func (l *loader) findImportSpec(f *ast.File, searchpos int) *ast.ImportSpec {
+	for _, spec := range importsOf(f) {
+		start := l.fset.Position(spec.Pos()).Offset
+		end := start + int(spec.End()-spec.Pos())
+		if start <= searchpos && searchpos <= end {
+			return spec
+		}
+	}
+
+	return nil
+}
+
+// importSpecOf returns the import spec of f, importing package of typ with
+// given name, nil if there isn't one.
+func (l *loader) importSpecOf(f *ast.File, typ types.Type, name string) *ast.ImportSpec {
+	for _, spec := range importsOf(f) {
+		if specPath(spec) == typNodeName(typ.Node) && l.importedName(spec) == name {
+			return spec
+		}
+	}
+
+	return nil
+}
+
+// usePkgName makes the package imported by spec of f the subject
+func (ctx *Context) usePkgName(f *ast.File, spec *ast.ImportSpec) error {
+	ctx.Subject = ctx.newPkgNameSub(spec)
+	if !ctx.Importing {
+		// qualifiers are scoped to the importing file
+		ctx.Scope = f
+	}
+
+	ctx.debugp("subject is package name %v", ctx.Subject)
+	return nil
+}
+
+func (ctx *Context) newPkgNameSub(spec *ast.ImportSpec) *pkgNameSub {
+	return &pkgNameSub{debugger: ctx.newDebugger(), loader: ctx.loader,
+		spec:      spec,
+		path:      specPath(spec),
+		name:      ctx.importedName(spec),
+		importing: ctx.Importing}
+}
+
+func specPath(spec *ast.ImportSpec) string {
+	p, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return ""
+	}
+
+	return p
+}
+
+// importedName gives the name which spec imports package as, the package
+// name if it's not renamed. "_" and "." are given as they are.
+func (l *loader) importedName(spec *ast.ImportSpec) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+
+	p := specPath(spec)
+	if pkg := l.importer(p); pkg != nil && pkg.Name != "" {
+		return pkg.Name
+	}
+	return path.Base(p)
+}
+
+// importNameOf gives where spec names the package, the path if it's not
+// renamed.
+func importNameOf(spec *ast.ImportSpec) ast.Expr {
+	if spec.Name != nil {
+		return spec.Name
+	}
+
+	return spec.Path
+}
+
+// importedBy reports whether spec imports the subject, only the same name
+// counts unless every importing file is searched.
+func (subject *pkgNameSub) importedBy(spec *ast.ImportSpec) bool {
+	if specPath(spec) != subject.path {
+		return false
+	}
+
+	return subject.importing || subject.importedName(spec) == subject.name
+}
+
+func (subject *pkgNameSub) IsMe(e ast.Expr, pkg *ast.Package) bool {
+	n, ok := e.(*ast.Ident)
+	if !ok || n.Name == "_" || n.Name == "." {
+		return false
+	}
+	if !subject.importing && n.Name != subject.name {
+		return false
+	}
+
+	_, typ := subject.exprType(n, pkg)
+	subject.debugp("pkgNameSub.IsMe() matching node type %v", typ)
+	return typ.Kind == ast.Pkg && typNodeName(typ.Node) == subject.path
+}
+
+func (subject *pkgNameSub) DeclPos() token.Pos {
+	return importNameOf(subject.spec).Pos()
+}
+
+// DeclEnd gives the end of import name, or of the import path if the
+// package is not renamed
+func (subject *pkgNameSub) DeclEnd() token.Pos {
+	return importNameOf(subject.spec).End()
+}
+
+func (subject *pkgNameSub) Toast() {}
+
+func (subject *pkgNameSub) Name() string {
+	return subject.name
+}
+
+func (subject *pkgNameSub) Kind() string {
+	return ast.Pkg.String()
+}
+
+func (subject *pkgNameSub) String() string {
+	return fmt.Sprintf("pkgNameSub, path %s, name %s, importing %v", subject.path, subject.name, subject.importing)
+}
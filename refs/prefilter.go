@@ -0,0 +1,141 @@
+package refs
+
+import (
+	"bytes"
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/parser"
+	"code.google.com/p/rog-go/exp/go/token"
+	"path/filepath"
+	"strconv"
+)
+
+// Stats tells how much work a search has done
+type Stats struct {
+	Files   int // files in search scope
+	Pruned  int // files skipped by prefilter, which can't refer the subject
+	Parsed  int // files parsed
+	Scanned int // files scanned for references
+}
+
+func (s *Stats) add(other Stats) {
+	s.Files += other.Files
+	s.Pruned += other.Pruned
+	s.Parsed += other.Parsed
+	s.Scanned += other.Scanned
+}
+
+// prefilter prunes the files can't refer the subject: the ones without its
+// name, and for package level subjects, the ones of other packages not
+// importing the declaring package. Files of packages having any candidate
+// are given to be parsed, since types are resolved in whole packages, but
+// only the candidates will be scanned.
+func (ctx *Context) prefilter(filenames []string, declPos token.Position) []string {
+	name := []byte(ctx.Subject.Name())
+	if subject, ok := ctx.Subject.(*pkgNameSub); ok {
+		// packages might be renamed by importing files, but their paths
+		// are always there
+		name = []byte(subject.path)
+	}
+	declDir, declPath := "", ""
+	if declPos.IsValid() && ctx.Scope == nil && isPkgLevelDecl(ctx, declPos) {
+		declDir = filepath.Dir(declPos.Filename)
+		declPath = ctx.dirImportPath(declDir)
+	}
+
+	candidate := make([]bool, len(filenames))
+	ctx.parallel(len(filenames), func(i int) {
+		src, err := ctx.ReadFile(filenames[i])
+		if err != nil {
+			// errors are reported by parsing
+			candidate[i] = true
+			return
+		}
+		if !bytes.Contains(src, name) {
+			return
+		}
+
+		dir := filepath.Dir(filenames[i])
+		if declDir == "" || dir == declDir {
+			candidate[i] = true
+			return
+		}
+
+		// other packages refer package level identifiers by importing
+		f, _ := parser.ParseFile(token.NewFileSet(), filenames[i], src, parser.ImportsOnly, nil)
+		if f == nil {
+			candidate[i] = true
+			return
+		}
+		ctx.rewriteVendorImports(f, dir)
+		for _, spec := range importsOf(f) {
+			if path, err := strconv.Unquote(spec.Path.Value); err == nil && path == declPath {
+				candidate[i] = true
+				return
+			}
+		}
+	})
+
+	dirs := make(map[string]bool)
+	ctx.pruned = make(map[string]bool)
+	for i, filename := range filenames {
+		if candidate[i] {
+			dirs[filepath.Dir(filename)] = true
+		} else {
+			ctx.pruned[filename] = true
+		}
+	}
+
+	var parsed []string
+	for _, filename := range filenames {
+		if dirs[filepath.Dir(filename)] {
+			parsed = append(parsed, filename)
+		}
+	}
+
+	ctx.Stats.Pruned = len(ctx.pruned)
+	ctx.debugp("Prefilter: %d of %d files pruned, %d to parse", ctx.Stats.Pruned, len(filenames), len(parsed))
+	return parsed
+}
+
+// isPkgLevelDecl reports whether the identifier declared at declPos is a
+// package level one. Methods and fields might be referred through values
+// of other packages without importing.
+func isPkgLevelDecl(ctx *Context, declPos token.Position) bool {
+	src, err := ctx.ReadFile(declPos.Filename)
+	if err != nil {
+		return false
+	}
+	fset := token.NewFileSet()
+	f, _ := parser.ParseFile(fset, declPos.Filename, src, 0, ast.NewScope(parser.Universe))
+	if f == nil {
+		return false
+	}
+
+	declared := func(id *ast.Ident) bool {
+		return id != nil && fset.Position(id.Pos()).Offset == declPos.Offset
+	}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil && declared(decl.Name) {
+				return true
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if declared(spec.Name) {
+						return true
+					}
+				case *ast.ValueSpec:
+					for _, id := range spec.Names {
+						if declared(id) {
+							return true
+						}
+					}
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package refs
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPrefilterStats(t *testing.T) {
+	// Needle is declared in prune.go, and referred by user/user.go.
+	// other/other.go has the name but doesn't import the package,
+	// plain/plain.go and hay.go don't have the name, hay.go is parsed
+	// along with prune.go though.
+	q := Query{
+		FileName: "../tests/pkg/prune/prune.go",
+		Offset:   61,
+		Path:     "../tests/pkg/prune",
+		Recurse:  true,
+		Jobs:     1,
+	}
+	result, err := Find(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Stats{Files: 5, Pruned: 3, Parsed: 3, Scanned: 2}
+	if result.Stats != want {
+		t.Errorf("stats %+v, want %+v", result.Stats, want)
+	}
+	if len(result.References) != 2 {
+		t.Errorf("%d references, want 2: %v", len(result.References), result.References)
+	}
+}
@@ -0,0 +1,403 @@
+// Package refs finds references of Go identifiers.
+//
+// Types are resolved by "code.google.com/p/rog-go/exp/go/types". Imported
+// packages are looked up in Query.GoPath, $GOPATH and GOROOT by default.
+// If the search path is in a Go module, imports are resolved by its go.mod
+// first, with local replacements, vendor directory and the module cache.
+// Every search has its own file set and imported packages, searches might
+// run concurrently.
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/token"
+	"context"
+	"go/build"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Query describes the identifier to search for, and where to search.
+type Query struct {
+	FileName string // Go source file where the identifier is
+	Offset   int    // byte offset of the identifier in FileName
+	Symbol   string // qualified name like "import/path.Type.Method", instead of FileName and Offset
+	Path     string // directory to search in, see ScopeImporters if it's empty
+	Recurse  bool   // search sub-directories of Path, too
+	Scope    string // ScopePath by default, or ScopeImporters, which always recurses
+
+	Mode     string // ModeRefs by default, or ModeImplements
+	Dispatch bool   // match methods through interface dynamic dispatch
+	Jobs     int    // number of packages parsed, and files scanned, concurrently, runtime.NumCPU() if 0
+	Index    string // directory of reference index, which is not used if it's empty
+
+	// roots of source trees imported packages are looked up in, like
+	// $GOPATH/src and $GOROOT/src, the ones of $GOPATH and GOROOT if it's
+	// empty
+	GoPath []string
+
+	// imported packages are kept by Cache for the following searches, and
+	// taken from it, if it's not nil
+	Cache *Cache
+
+	// kinds of references wanted, all of them if it's empty
+	Kinds []string
+
+	// files are selected by build constraints, for GOOS and GOARCH, the
+	// default ones if they're empty, with Tags. With AllConfigs, files are
+	// selected for every known GOOS and GOARCH, references are merged.
+	GOOS, GOARCH string
+	Tags         []string
+	AllConfigs   bool
+
+	// _test.go files, of both in-package and external tests, are not
+	// searched
+	SkipTests bool
+
+	// names of imported packages are searched in every file importing
+	// the package, not just the one of the subject
+	Importing bool
+
+	// contents of modified files, which are not saved yet, by file name
+	Overlay map[string][]byte
+
+	// debug messages are written to Logger, if it's not nil
+	Logger *log.Logger
+}
+
+// Reference is a position where the subject is referred, or declared.
+type Reference struct {
+	Start token.Position // position of the name
+	End   token.Position // position right after the name
+	Func  string         // name of the enclosing function declaration, if any
+	Kind  string         // KindDecl, KindWrite, KindRead, KindCall, KindKey, KindType or KindImport
+	Test  bool           // in _test.go file
+}
+
+// Result is what has been found for a query.
+type Result struct {
+	Name    string         // name of the subject
+	Kind    string         // "method", "field", "func", "var", "type", etc.
+	Package string         // package which declares the subject
+	DeclPos token.Position // declaration position of the subject
+	DeclEnd token.Position // end of the declaring name, or of the import path
+
+	References []Reference
+	Stats      Stats // how much work has been done
+}
+
+// FindReferences returns all references of the identifier given by q.
+func FindReferences(ctx context.Context, q Query) ([]Reference, error) {
+	result, err := Find(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.References, nil
+}
+
+// Find searches for the identifier given by q, returns the description of
+// subject, and all its references. If the declaration of subject is not
+// visited by search but it's inside the search path, it's a reference too.
+// References are sorted by file name, line and column.
+func Find(ctx context.Context, q Query) (*Result, error) {
+	q.Overlay = resolveOverlay(q.Overlay)
+	for _, kind := range q.Kinds {
+		if !isKind(kind) {
+			return nil, errorGenerator("unknown kind of reference %s", kind)
+		}
+	}
+
+	if q.AllConfigs {
+		return findAllConfigs(ctx, q)
+	}
+	return find(ctx, q, q.buildContext(q.GOOS, q.GOARCH))
+}
+
+// resolveOverlay gives the overlay by real paths of file names, which are
+// compared with the ones processed the same way
+func resolveOverlay(overlay map[string][]byte) map[string][]byte {
+	if len(overlay) == 0 {
+		return overlay
+	}
+
+	resolved := make(map[string][]byte)
+	for name, src := range overlay {
+		if path, err := realPath(name); err == nil {
+			name = path
+		}
+		resolved[name] = src
+	}
+	return resolved
+}
+
+// find searches for the identifier given by q, in files selected by bctx
+func find(ctx context.Context, q Query, bctx *build.Context) (*Result, error) {
+	// the root is found by the declaring package, till then the directory
+	// of identifier stands for it
+	rooted := q.Path == "" && q.Scope == ScopeImporters
+	dir := q.Path
+	if rooted {
+		dir = filepath.Dir(q.FileName)
+	}
+	path, err := realPath(dir)
+	if err != nil {
+		return nil, errorGenerator("cannot resolve path %s, %v", dir, err)
+	}
+
+	c := NewContext("", q.Offset, path)
+	if q.Mode != "" {
+		c.Mode = q.Mode
+	}
+	if c.Mode != ModeRefs && c.Mode != ModeImplements {
+		return nil, errorGenerator("unknown search mode %s", c.Mode)
+	}
+	c.Dispatch = q.Dispatch
+	c.Overlay = q.Overlay
+	c.Build = bctx
+	c.SkipTests = q.SkipTests
+	c.Importing = q.Importing
+
+	if q.Scope != "" && q.Scope != ScopePath && q.Scope != ScopeImporters {
+		return nil, errorGenerator("unknown search scope %s", q.Scope)
+	}
+
+	var filenames []string
+	if !rooted {
+		if filenames, err = getFileNames(q.Recurse || q.Scope == ScopeImporters, path, q.Overlay); err != nil {
+			return nil, errorGenerator("cannot find any go file in %s, %v", path, err)
+		}
+		filenames = c.filterFiles(filenames)
+	}
+
+	c.Logger = q.Logger
+	c.Cancel = ctx.Done()
+	c.Jobs = q.Jobs
+	if c.Jobs == 0 {
+		c.Jobs = runtime.NumCPU()
+	}
+
+	// imports are resolved by go.mod of the searched module, if any
+	c.loader = newLoader(path, q.GoPath, bctx, q.Overlay, q.Cache)
+	if q.Symbol != "" {
+		if c.FileName, c.SearchPos, err = c.findSymbol(q.Symbol); err != nil {
+			return nil, err
+		}
+	} else if c.FileName, err = realPath(q.FileName); err != nil {
+		return nil, errorGenerator("cannot resolve file %s, %v", q.FileName, err)
+	}
+	if c.SkipTests && isTestFile(c.FileName) {
+		return nil, errorGenerator("%s is a test file, but tests are skipped", c.FileName)
+	}
+	if !c.matchFile(c.FileName) {
+		return nil, FileExcluded
+	}
+
+	result := &Result{}
+	c.RefPrinter = func(n ast.Expr, fn string, kind string) {
+		ref := newReference(c, n, fn, kind)
+		result.References = append(result.References, ref)
+	}
+
+	if err = c.ParseSubject(); err == NoIdentifier {
+		return nil, err
+	} else if err != nil {
+		return nil, errorGenerator("parse identifier failed, %v", err)
+	}
+	result.Name = c.Subject.Name()
+	result.Kind = c.Subject.Kind()
+	result.Package = c.pkgNameOfPos(c.Subject.DeclPos())
+	result.DeclPos = c.fset.Position(c.Subject.DeclPos())
+	result.DeclEnd = c.fset.Position(c.Subject.DeclEnd())
+
+	if rooted {
+		declDir := path
+		if result.DeclPos.IsValid() {
+			declDir = filepath.Dir(result.DeclPos.Filename)
+		}
+		if path = c.rootOf(declDir); path == "" {
+			return nil, errorGenerator("cannot find the module or GOPATH tree of %s", declDir)
+		}
+		c.Path = path
+		if filenames, err = getFileNames(true, path, q.Overlay); err != nil {
+			return nil, errorGenerator("cannot find any go file in %s, %v", path, err)
+		}
+		filenames = c.filterFiles(filenames)
+	}
+
+	if position, ok := c.SubjectDeclPos(); ok {
+		decl := Reference{Start: position, End: result.DeclEnd, Kind: KindDecl, Test: isTestFile(position.Filename)}
+		result.References = append(result.References, decl)
+	}
+
+	// packages can't refer the subject without importing its package,
+	// universe ones are everywhere
+	_, pkgName := c.Subject.(*pkgNameSub)
+	if q.Scope == ScopeImporters && c.Scope == nil && result.DeclPos.IsValid() && !pkgName {
+		filenames = c.importers(filenames, filepath.Dir(result.DeclPos.Filename))
+	}
+	c.Stats.Files = len(filenames)
+	all := filenames
+
+	// files without the name can't refer the subject, implementations are
+	// named otherwise
+	if c.Mode == ModeRefs && c.Scope == nil {
+		filenames = c.prefilter(filenames, result.DeclPos)
+	}
+
+	// local identifiers are scanned in their scope, which is fast enough
+	var idx *index
+	if q.Index != "" && c.Scope == nil {
+		idx = loadIndex(q.Index, path)
+		cached, err := idx.scan(c, all, filenames)
+		if err != nil {
+			return nil, err
+		}
+		result.References = append(result.References, cached...)
+	} else if err = c.ScanFiles(filenames); err != nil {
+		return nil, err
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if idx != nil {
+		if err = idx.save(); err != nil {
+			c.debugp("cannot save index %s, %v", idx.filename, err)
+		}
+	}
+
+	if len(q.Kinds) != 0 {
+		result.References = filterKinds(result.References, q.Kinds)
+	}
+
+	// references are found in random order by concurrent scanning
+	sort.Sort(byPosition(result.References))
+	result.Stats = c.Stats
+	return result, nil
+}
+
+func filterKinds(refs []Reference, kinds []string) []Reference {
+	var wanted []Reference
+	for _, ref := range refs {
+		for _, kind := range kinds {
+			if ref.Kind == kind {
+				wanted = append(wanted, ref)
+				break
+			}
+		}
+	}
+
+	return wanted
+}
+
+func newReference(c *Context, n ast.Expr, fn string, kind string) Reference {
+	start := c.WhereIs(n)
+	return Reference{Start: start, End: c.WhereEnds(n), Func: fn, Kind: kind, Test: isTestFile(start.Filename)}
+}
+
+// byPosition sorts references by file name, line and column
+type byPosition []Reference
+
+func (refs byPosition) Len() int      { return len(refs) }
+func (refs byPosition) Swap(i, j int) { refs[i], refs[j] = refs[j], refs[i] }
+func (refs byPosition) Less(i, j int) bool {
+	p1, p2 := refs[i].Start, refs[j].Start
+	switch {
+	case p1.Filename != p2.Filename:
+		return p1.Filename < p2.Filename
+	case p1.Line != p2.Line:
+		return p1.Line < p2.Line
+	}
+	return p1.Column < p2.Column
+}
+
+// realPath gives the absolute path, with symlinks resolved
+func realPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(abs)
+}
+
+func isGoFile(d os.FileInfo) bool {
+	return strings.HasSuffix(d.Name(), ".go") &&
+		!strings.HasPrefix(d.Name(), ".")
+}
+
+// getFileNames gives the go files in path, and in its sub-directories if
+// recurse is true. Files which are only in overlay are given too.
+func getFileNames(recurse bool, path string, overlay map[string][]byte) ([]string, error) {
+	filenames, err := readFileNames(recurse, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return withOverlay(filenames, overlay, path, recurse), nil
+}
+
+// withOverlay adds the go files of overlay in dir, or in its sub-directories
+// if recurse is true, which are not in filenames yet.
+func withOverlay(filenames []string, overlay map[string][]byte, dir string, recurse bool) []string {
+	listed := make(map[string]bool)
+	for _, filename := range filenames {
+		listed[filename] = true
+	}
+
+	dir = filepath.Clean(dir)
+	var added []string
+	for filename := range overlay {
+		name := filepath.Base(filename)
+		if listed[filename] || !strings.HasSuffix(name, ".go") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if d := filepath.Dir(filename); d == dir || recurse && strings.HasPrefix(d, dir+string(filepath.Separator)) {
+			added = append(added, filename)
+		}
+	}
+
+	// overlay is in random order
+	sort.Strings(added)
+	return append(filenames, added...)
+}
+
+func readFileNames(recurse bool, path string) ([]string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	list, err := fd.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := make([]string, 0)
+	n := 0
+	for i := 0; i < len(list); i++ {
+		d := list[i]
+		absPath := filepath.Join(path, d.Name())
+
+		switch {
+		case d.IsDir() && recurse:
+			fns, err := readFileNames(recurse, absPath)
+			if err != nil {
+				return nil, err
+			}
+
+			filenames = append(filenames, fns...)
+			n += len(fns)
+		case isGoFile(d):
+			filenames = append(filenames, absPath)
+			n++
+		}
+	}
+
+	return filenames[:n], nil
+}
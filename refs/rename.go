@@ -0,0 +1,281 @@
+package refs
+
+import (
+	"bytes"
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/parser"
+	"code.google.com/p/rog-go/exp/go/scanner"
+	"code.google.com/p/rog-go/exp/go/token"
+	"context"
+	gotoken "go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Rename finds references of the identifier given by q, and renames all of
+// them to name. New contents of the changed files are returned by file
+// name, nothing is written.
+//
+// Renaming is refused if the declaration is not in the search path, if
+// an exported identifier referred by other packages would be unexported,
+// or if the renamed identifier conflicts with existing ones. Conflicts are
+// found by searching the renamed declaring package again, the references
+// must be exactly the renamed ones, and the new name must not be declared
+// again in their scopes. External tests are other packages.
+func Rename(ctx context.Context, q Query, name string) (map[string][]byte, error) {
+	if !gotoken.IsIdentifier(name) {
+		return nil, errorGenerator("%s is not a valid identifier", name)
+	}
+	if q.Mode != "" && q.Mode != ModeRefs {
+		return nil, errorGenerator("cannot rename in %s mode", q.Mode)
+	}
+	// declarations of implementations and references of other kinds would
+	// be left behind
+	if q.Dispatch {
+		return nil, errorGenerator("cannot rename following dynamic dispatch")
+	}
+	if len(q.Kinds) != 0 {
+		return nil, errorGenerator("cannot rename references of given kinds only")
+	}
+
+	// every reference is renamed, found by scanning files
+	q.Index = ""
+	q.Overlay = resolveOverlay(q.Overlay)
+	result, err := Find(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if result.Name == name {
+		return nil, errorGenerator("%s is the current name", name)
+	}
+	if q.Importing {
+		if err := sameImportedName(q.Overlay, result); err != nil {
+			return nil, err
+		}
+	}
+
+	declDir := filepath.Dir(result.DeclPos.Filename)
+	declPkg := packageClause(q.Overlay, result.DeclPos.Filename)
+	declared := false
+	for _, ref := range result.References {
+		if samePosition(ref.Start, result.DeclPos) {
+			declared = true
+		}
+		if !ast.IsExported(result.Name) || ast.IsExported(name) {
+			continue
+		}
+		// external tests are other packages in the same directory
+		if filepath.Dir(ref.Start.Filename) != declDir ||
+			packageClause(q.Overlay, ref.Start.Filename) != declPkg {
+			return nil, errorGenerator("cannot unexport %s, it's referred by other package at %s",
+				result.Name, ref.Start)
+		}
+	}
+	if !declared {
+		return nil, errorGenerator("declaration of %s at %s is not in search path", result.Name, result.DeclPos)
+	}
+
+	renamed, offsets, err := rewrite(q.Overlay, result, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// search renamed declaring package, types of other packages are read
+	// from saved files by the type resolver, they can't be checked.
+	overlay := make(map[string][]byte)
+	for filename, src := range q.Overlay {
+		overlay[filename] = src
+	}
+	for filename, src := range renamed {
+		overlay[filename] = src
+	}
+	check, err := Find(ctx, Query{
+		FileName:  result.DeclPos.Filename,
+		Offset:    offsets[result.DeclPos.Filename][result.DeclPos.Offset],
+		Path:      declDir,
+		Jobs:      q.Jobs,
+		GOOS:      q.GOOS,
+		GOARCH:    q.GOARCH,
+		Tags:      q.Tags,
+		SkipTests: q.SkipTests,
+		Importing: q.Importing,
+		Overlay:   overlay,
+		Logger:    q.Logger,
+	})
+	if err != nil {
+		return nil, errorGenerator("cannot check renamed %s, %v", name, err)
+	}
+
+	// renamed references in declaring package, by file name and offset
+	type position struct {
+		filename string
+		offset   int
+	}
+	want := make(map[position]bool)
+	for _, ref := range result.References {
+		if filepath.Dir(ref.Start.Filename) == declDir {
+			want[position{ref.Start.Filename, offsets[ref.Start.Filename][ref.Start.Offset]}] = true
+		}
+	}
+	found := make(map[position]bool)
+	for _, ref := range check.References {
+		if filepath.Dir(ref.Start.Filename) != declDir {
+			continue
+		}
+
+		p := position{ref.Start.Filename, ref.Start.Offset}
+		if check.Name != name || !want[p] {
+			return nil, errorGenerator("renaming %s to %s conflicts with declaration at %s",
+				result.Name, name, check.DeclPos)
+		}
+		found[p] = true
+	}
+	if len(found) != len(want) {
+		return nil, errorGenerator("renaming %s to %s conflicts with existing %s in its scopes",
+			result.Name, name, name)
+	}
+
+	// the other declaration in the same scope has its own object, which is
+	// not a reference
+	if pos := redeclared(q, overlay, declDir, declPkg, name); pos != "" {
+		return nil, errorGenerator("renaming %s to %s conflicts with declaration at %s",
+			result.Name, name, pos)
+	}
+
+	return renamed, nil
+}
+
+// redeclared gives the position where name is declared again in one
+// scope of package pkgName in dir, empty if it's not.
+func redeclared(q Query, overlay map[string][]byte, dir, pkgName, name string) string {
+	c := NewContext("", 0, dir)
+	c.Build = q.buildContext(q.GOOS, q.GOARCH)
+	c.SkipTests = q.SkipTests
+	c.Overlay = overlay
+
+	filenames, err := getFileNames(false, dir, overlay)
+	if err != nil {
+		return ""
+	}
+	fset := token.NewFileSet()
+	scope := ast.NewScope(parser.Universe)
+	for _, filename := range c.filterFiles(filenames) {
+		if packageClause(overlay, filename) != pkgName {
+			continue
+		}
+		src, err := c.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+
+		_, err = parser.ParseFile(fset, filename, src, parser.DeclarationErrors, scope)
+		if list, ok := err.(scanner.ErrorList); ok {
+			for _, e := range list {
+				if strings.HasPrefix(e.Msg, name+" redeclared") {
+					return e.Pos.String()
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// sameImportedName fails if any file imports the package named by result
+// under another name, renaming all of them is not supported.
+func sameImportedName(overlay map[string][]byte, result *Result) error {
+	sources := make(map[string][]byte)
+	for _, ref := range result.References {
+		src, ok := sources[ref.Start.Filename]
+		if !ok {
+			if src, ok = overlay[ref.Start.Filename]; !ok {
+				var err error
+				if src, err = ioutil.ReadFile(ref.Start.Filename); err != nil {
+					return err
+				}
+			}
+			sources[ref.Start.Filename] = src
+		}
+
+		start, end := ref.Start.Offset, ref.End.Offset
+		if end > len(src) || start >= end || src[start] == '"' || src[start] == '`' {
+			// import path, the package is not renamed by the spec
+			continue
+		}
+		if imported := string(src[start:end]); imported != result.Name {
+			return errorGenerator("cannot rename %s, the package is imported as %s at %s, renaming packages imported under different names is not supported",
+				result.Name, imported, ref.Start)
+		}
+	}
+	return nil
+}
+
+// packageClause gives the package name of filename, contents of modified
+// files are taken from overlay
+func packageClause(overlay map[string][]byte, filename string) string {
+	var src interface{}
+	if modified, ok := overlay[filename]; ok {
+		src = modified
+	}
+	f, _ := parser.ParseFile(token.NewFileSet(), filename, src, parser.PackageClauseOnly, nil)
+	if f == nil {
+		return ""
+	}
+	return f.Name.Name
+}
+
+// rewrite replaces every reference of result with name, gives new contents
+// of files, and new offset of every reference by file name and old offset.
+func rewrite(overlay map[string][]byte, result *Result, name string) (map[string][]byte, map[string]map[int]int, error) {
+	byFile := make(map[string][]int)
+	for _, ref := range result.References {
+		byFile[ref.Start.Filename] = append(byFile[ref.Start.Filename], ref.Start.Offset)
+	}
+
+	renamed := make(map[string][]byte)
+	offsets := make(map[string]map[int]int)
+	for filename, starts := range byFile {
+		src, ok := overlay[filename]
+		if !ok {
+			var err error
+			if src, err = ioutil.ReadFile(filename); err != nil {
+				return nil, nil, err
+			}
+		}
+		sort.Ints(starts)
+
+		var buf bytes.Buffer
+		offsets[filename] = make(map[int]int)
+		last := 0
+		for _, start := range starts {
+			if start < last {
+				// reported twice
+				continue
+			}
+
+			if start < len(src) && (src[start] == '"' || src[start] == '`') {
+				// path of import spec, the package is renamed by
+				// giving the name
+				buf.Write(src[last:start])
+				offsets[filename][start] = buf.Len()
+				buf.WriteString(name + " ")
+				last = start
+				continue
+			}
+
+			end := start + len(result.Name)
+			if end > len(src) || string(src[start:end]) != result.Name {
+				return nil, nil, errorGenerator("%s is not found at %s:%d", result.Name, filename, start)
+			}
+			buf.Write(src[last:start])
+			offsets[filename][start] = buf.Len()
+			buf.WriteString(name)
+			last = end
+		}
+		buf.Write(src[last:])
+		renamed[filename] = buf.Bytes()
+	}
+
+	return renamed, offsets, nil
+}
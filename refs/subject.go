@@ -0,0 +1,637 @@
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/ast"
+	"code.google.com/p/rog-go/exp/go/token"
+	"code.google.com/p/rog-go/exp/go/types"
+	"fmt"
+	"strings"
+)
+
+type Subject interface {
+	IsMe(ast.Expr, *ast.Package) bool
+	DeclPos() token.Pos
+	DeclEnd() token.Pos // end of the declaring node, like DeclPos
+	Toast()
+
+	Name() string
+	Kind() string // "method", "field", "func", "var", "type", etc.
+}
+
+type selectorSub struct {
+	debugger
+	*loader
+
+	self *ast.SelectorExpr
+
+	typ types.Type
+	obj *ast.Object
+
+	declPos token.Position
+
+	recv types.Type // receiver _type_
+
+	// match methods of implementations if recv is an interface, or
+	// methods of satisfied interfaces if recv is a concrete type
+	dispatch bool
+}
+
+func (subject *selectorSub) IsMe(e ast.Expr, pkg *ast.Package) (found bool) {
+	switch n := e.(type) {
+	default:
+		found = false
+	case *ast.SelectorExpr:
+		if !subject.hasSameName(n.Sel) {
+			return false
+		}
+
+		recvTyp := subject.typeOf(n.X, pkg)
+		if subject.obj.Kind == ast.Fun && recvTyp.Kind == ast.Typ &&
+			!subject.inMethodSet(recvTyp, n.Sel.Name, isPointer(recvTyp.Node)) {
+			// method expression, T.M or (*T).M, M has to be in
+			// the method set of T or *T
+			return false
+		}
+
+		found = subject.hasSameRecvTyp(recvTyp)
+	case *ast.Ident:
+		// This case is suppose to handle package level
+		// variable/struct/interface/function names.
+		if subject.recv.Kind != ast.Pkg {
+			// This is NOT a package level selector
+			return false
+		}
+
+		// Compare ident with self.Sel only.
+		if !subject.hasSameName(n) {
+			return false
+		}
+
+		identTyp := subject.typeOf(n, pkg)
+		subject.debugp("Ident type %v", identTyp)
+		if identTyp.Kind == ast.Bad {
+			return false
+		}
+
+		if subject.samePosWithSelf(n) {
+			// it's the select subject itself, at declaration place, it will be
+			// outputed by context by another way
+			return false
+		}
+
+		pkgNameOfIdent := subject.pkgNameOfPos(identTyp.Node.Pos())
+		found = pkgNameOfIdent == subject.pkgNameOfPos(subject.DeclPos())
+	}
+
+	return
+}
+
+func (subject *selectorSub) samePosWithSelf(n *ast.Ident) bool {
+	identPos := subject.fset.Position(n.Pos())
+
+	subject.debugp("selectorSub ident position %v", identPos)
+	return identPos.IsValid() &&
+		identPos.Filename == subject.declPos.Filename &&
+		identPos.Offset == subject.declPos.Offset
+}
+
+func (subject *selectorSub) hasSameName(e *ast.Ident) bool {
+	return e.Name == subject.self.Sel.Name
+}
+
+func (subject *selectorSub) hasSameRecvTyp(typ types.Type) bool {
+	if typ.Kind == ast.Bad {
+		return false
+	}
+
+	// local recv might got a empty package name
+	subject.regainPkgName(&typ, typ.Node.Pos())
+	subject.debugp("selectorSub.hasSameRecvTyp() matching recv type %v", typ)
+
+	if sameDeclType(typ, subject.recv) {
+		return true
+	}
+
+	// might be a `promoted` field or method, find the type which
+	// really owns it through the embedding chain
+	owner, ok := subject.ownerOf(typ, subject.self.Sel.Name)
+	if !ok {
+		return false
+	}
+	subject.debugp("selectorSub.hasSameRecvTyp() matching owner type %v", owner)
+
+	if sameDeclType(owner, subject.recv) {
+		return true
+	}
+
+	return subject.dispatch && subject.sameDispatch(owner)
+}
+
+// sameDispatch reports whether the method of owner and the subject method
+// might be the same one at runtime, through interface dynamic dispatch.
+func (subject *selectorSub) sameDispatch(owner types.Type) bool {
+	if subject.obj.Kind != ast.Fun {
+		// fields are never dispatched
+		return false
+	}
+
+	_, recvIface := typeSpecOf(subject.recv).(*ast.InterfaceType)
+	_, ownerIface := typeSpecOf(owner).(*ast.InterfaceType)
+	switch {
+	// methods of both T and *T are called through an interface value
+	// holding *T
+	case recvIface && !ownerIface:
+		return subject.implements(owner, subject.recv, true)
+	case !recvIface && ownerIface:
+		return subject.implements(subject.recv, owner, true)
+	}
+
+	return false
+}
+
+func (subject *selectorSub) DeclPos() token.Pos {
+	return types.DeclPos(subject.obj)
+}
+
+func (subject *selectorSub) DeclEnd() token.Pos {
+	return identEnd(subject.DeclPos(), subject.obj.Name)
+}
+
+func (subject *selectorSub) Toast() {
+	subject.regainPkgName(&subject.typ, subject.DeclPos())
+	subject.regainPkgName(&subject.recv, subject.recv.Node.Pos())
+	subject.declPos = subject.fset.Position(subject.DeclPos())
+}
+
+func (subject *selectorSub) Name() string {
+	return subject.self.Sel.Name
+}
+
+func (subject *selectorSub) Kind() string {
+	if subject.recv.Kind != ast.Pkg {
+		switch subject.obj.Kind {
+		case ast.Fun:
+			return "method"
+		case ast.Var:
+			return "field"
+		}
+	}
+
+	return subject.obj.Kind.String()
+}
+
+func (subject *selectorSub) String() string {
+	s := fmt.Sprintf("selectorSub, self %v", subject.self)
+	s += fmt.Sprintf(" typ: %v", subject.typ)
+	s += fmt.Sprintf(" obj: %v", subject.obj)
+	s += fmt.Sprintf(" recv typ: %v", subject.recv)
+	s += fmt.Sprintf(" dispatch: %v", subject.dispatch)
+	s += fmt.Sprintf(" decl pos: %v", subject.declPos)
+
+	return s
+}
+
+type identSub struct {
+	debugger
+	*loader
+
+	self *ast.Ident
+
+	typ types.Type
+	obj *ast.Object
+
+	declPos token.Position
+
+	// the type switch statement which declares subject, if subject
+	// is the symbol of a type switch
+	typeSwitch *ast.TypeSwitchStmt
+}
+
+func (subject *identSub) IsMe(e ast.Expr, pkg *ast.Package) (found bool) {
+	switch n := e.(type) {
+	default:
+		found = false
+	case *ast.Ident:
+		if n.Name != subject.self.Name {
+			return false
+		}
+
+		obj, ityp := subject.exprType(n, pkg)
+		if subject.typeSwitch != nil && subject.isTypeSwitchSymbol(subject.typeSwitch, n, obj) {
+			// types of the symbol vary among case clauses, skip the
+			// comparison of types
+			return true
+		}
+
+		subject.regainPkgName(&ityp, n.Pos())
+		subject.debugp("identSub.IsMe() matching node type %v", ityp)
+		if !isIdenticalTyp(ityp, subject.typ) {
+			return false
+		}
+
+		nPos := subject.fset.Position(types.DeclPos(obj))
+		subject.debugp("identSub.IsMe()  n decl pos %v", nPos)
+		found = subject.sameDeclPos(nPos)
+	case *ast.SelectorExpr:
+		// This case is supposed to handle package level
+		// variable/struct/interface/function names
+		//
+		// Of SelectorExpr, only check Sel part,
+		// n.X will be checked by case *ast.Ident branch
+		if n.Sel.Name != subject.self.Name {
+			return false
+		}
+
+		recvTyp := subject.typeOf(n.X, pkg)
+		subject.debugp("identSub.IsMe() recv type %v", recvTyp)
+		if recvTyp.Kind != ast.Pkg {
+			return false
+		}
+
+		found = subject.pkgNameOfPos(subject.DeclPos()) == typNodeName(recvTyp.Node)
+	}
+
+	return
+}
+
+func (subject *identSub) sameDeclPos(nPos token.Position) bool {
+	return nPos.IsValid() &&
+		nPos.Filename == subject.declPos.Filename &&
+		nPos.Offset == subject.declPos.Offset
+}
+
+func (subject *identSub) DeclPos() token.Pos {
+	if subject.typeSwitch != nil {
+		return typeSwitchSymbol(subject.typeSwitch).Pos()
+	}
+
+	return types.DeclPos(subject.obj)
+}
+
+func (subject *identSub) DeclEnd() token.Pos {
+	if subject.typeSwitch != nil {
+		return typeSwitchSymbol(subject.typeSwitch).End()
+	}
+
+	return identEnd(subject.DeclPos(), subject.obj.Name)
+}
+
+func (subject *identSub) Toast() {
+	subject.regainPkgName(&subject.typ, subject.DeclPos())
+
+	subject.declPos = subject.fset.Position(subject.DeclPos())
+}
+
+func (subject *identSub) Name() string {
+	return subject.self.Name
+}
+
+func (subject *identSub) Kind() string {
+	if subject.obj == nil {
+		// symbol of type switch might be unresolved
+		return ast.Var.String()
+	}
+
+	return subject.obj.Kind.String()
+}
+
+func (subject *identSub) String() string {
+	s := fmt.Sprintf("identSub, self %v", subject.self)
+	s += fmt.Sprintf(" typ: %v", subject.typ)
+	s += fmt.Sprintf(" obj: %v", subject.obj)
+	s += fmt.Sprintf(" decl pos: %v", subject.declPos)
+
+	return s
+}
+
+// typeSwitchSymbol returns the symbol declared by `switch v := x.(type)`,
+// or nil if there's no symbol
+func typeSwitchSymbol(sw *ast.TypeSwitchStmt) *ast.Ident {
+	assign, ok := sw.Assign.(*ast.AssignStmt)
+	if !ok || len(assign.Lhs) != 1 {
+		return nil
+	}
+
+	ident, _ := assign.Lhs[0].(*ast.Ident)
+	return ident
+}
+
+// isTypeSwitchSymbol reports whether n, with object obj, is the symbol of
+// type switch sw. The symbol is declared implicitly in each case clause,
+// so objects of each clause are unified here with the declaring one.
+func (l *loader) isTypeSwitchSymbol(sw *ast.TypeSwitchStmt, n *ast.Ident, obj *ast.Object) bool {
+	symbol := typeSwitchSymbol(sw)
+	if symbol == nil || symbol.Name != n.Name {
+		return false
+	}
+
+	pos := l.fset.Position(n.Pos())
+	symbolPos := l.fset.Position(symbol.Pos())
+	if samePosition(pos, symbolPos) {
+		return true
+	}
+
+	for _, s := range sw.Body.List {
+		clause, ok := s.(*ast.CaseClause)
+		if !ok || !l.containsPosition(clause, pos) {
+			continue
+		}
+
+		if obj == nil {
+			// nothing else with the same name could be unresolved
+			return true
+		}
+		if obj.Decl == clause || obj.Decl == sw || obj.Decl == sw.Assign {
+			return true
+		}
+
+		// not shadowed by any declaration inside the case clause
+		declPos := l.fset.Position(types.DeclPos(obj))
+		if samePosition(declPos, symbolPos) {
+			return true
+		}
+		for _, stmt := range clause.Body {
+			if l.containsPosition(stmt, declPos) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+func samePosition(p1, p2 token.Position) bool {
+	return p1.IsValid() &&
+		p1.Filename == p2.Filename &&
+		p1.Offset == p2.Offset
+}
+
+func (l *loader) containsPosition(n ast.Node, p token.Position) bool {
+	start := l.fset.Position(n.Pos())
+	return p.IsValid() &&
+		start.Filename == p.Filename &&
+		start.Offset <= p.Offset &&
+		p.Offset < start.Offset+int(n.End()-n.Pos())
+}
+
+func sameDeclType(t1, t2 types.Type) bool {
+	switch {
+	case t1.Kind == ast.Typ || t2.Kind == ast.Typ:
+		// For composite literal, recv type can be ast.Typ or ast.Var, so if one
+		// of the types.Type is ast.Typ, do not compare the Kind anymore
+		if t1.Pkg != t2.Pkg {
+			return false
+		}
+
+		return sameNodeName(t1.Node, t2.Node)
+	case t1.Kind == ast.Pkg || t2.Kind == ast.Pkg:
+		// Packages do NOT need to be compared by types.Type.Pkg, it doesn't
+		// matter.
+		if t1.Kind != t2.Kind {
+			return false
+		}
+
+		return sameNodeName(t1.Node, t2.Node)
+	}
+
+	return isIdenticalTyp(t1, t2)
+}
+
+func isIdenticalTyp(t1, t2 types.Type) bool {
+	if t1.Pkg != t2.Pkg {
+		return false
+	}
+
+	if t1.Kind != t2.Kind {
+		return false
+	}
+
+	return sameNodeName(t1.Node, t2.Node)
+}
+
+func sameNodeName(n1, n2 ast.Node) bool {
+	return typNodeName(n1) == typNodeName(n2)
+}
+
+// identEnd gives the end of identifier name, which is declared at pos, as
+// types.DeclPos() tells
+func identEnd(pos token.Pos, name string) token.Pos {
+	if !pos.IsValid() {
+		return token.NoPos
+	}
+
+	return pos + token.Pos(len(name))
+}
+
+func typNodeName(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.ImportSpec:
+		return strings.Trim(n.Path.Value, "\"")
+	case *ast.Ident:
+		return n.Name
+	case *ast.StarExpr:
+		return typNodeName(n.X)
+	case *ast.ParenExpr:
+		return typNodeName(n.X)
+	}
+	return ""
+}
+
+// ownerOf walks the embedding chain of typ, level by level, and returns
+// the type who declares the field or method with given name. Members of
+// shallower level hide those of deeper level, like Go spec says.
+func (l *loader) ownerOf(typ types.Type, name string) (types.Type, bool) {
+	visited := make(map[string]bool)
+	level := []types.Type{typ}
+	for len(level) > 0 {
+		var next []types.Type
+		for _, t := range level {
+			key := t.Pkg + "." + typNodeName(t.Node)
+			if t.Kind == ast.Bad || visited[key] {
+				continue
+			}
+			visited[key] = true
+
+			if hasOwnMember(t, name) {
+				return t, true
+			}
+			next = append(next, l.embeddedTypes(t)...)
+		}
+		level = next
+	}
+
+	return types.Type{Kind: ast.Bad}, false
+}
+
+// hasOwnMember reports whether typ declares field or method with given
+// name by itself, promoted ones are not counted.
+func hasOwnMember(typ types.Type, name string) bool {
+	switch t := typeSpecOf(typ).(type) {
+	case *ast.StructType:
+		for _, fld := range t.Fields.List {
+			if len(fld.Names) == 0 && embeddedName(fld.Type) == name {
+				return true
+			}
+			for _, n := range fld.Names {
+				if n.Name == name {
+					return true
+				}
+			}
+		}
+	case *ast.InterfaceType:
+		for _, m := range t.Methods.List {
+			for _, n := range m.Names {
+				if n.Name == name {
+					return true
+				}
+			}
+		}
+	}
+
+	return ownMethod(typ, name) != nil
+}
+
+// ownMethod returns the declaration of method with given name, which is
+// declared with typ or *typ as receiver, promoted ones are not counted.
+func ownMethod(typ types.Type, name string) *ast.FuncDecl {
+	obj := typ.Member(name)
+	if obj == nil {
+		return nil
+	}
+	fdecl, ok := obj.Decl.(*ast.FuncDecl)
+	if !ok || fdecl.Recv == nil || len(fdecl.Recv.List) != 1 {
+		return nil
+	}
+
+	if !sameNodeName(fdecl.Recv.List[0].Type, typ.Node) {
+		return nil
+	}
+	return fdecl
+}
+
+// inMethodSet reports whether method with given name is in the method set
+// of typ, or of *typ if ptr is true. Methods with pointer receiver are in
+// the method set of T only if they're promoted through an embedded *E.
+func (l *loader) inMethodSet(typ types.Type, name string, ptr bool) bool {
+	type candidate struct {
+		typ types.Type
+		ptr bool // addressable through pointer
+	}
+
+	visited := make(map[string]bool)
+	level := []candidate{{typ, ptr || isPointer(typ.Node)}}
+	for len(level) > 0 {
+		var next []candidate
+		for _, c := range level {
+			key := c.typ.Pkg + "." + typNodeName(c.typ.Node)
+			if c.typ.Kind == ast.Bad || visited[key] {
+				continue
+			}
+			visited[key] = true
+
+			if hasOwnMember(c.typ, name) {
+				if _, ok := typeSpecOf(c.typ).(*ast.InterfaceType); ok {
+					return true
+				}
+
+				// fields are not in method set
+				fdecl := ownMethod(c.typ, name)
+				return fdecl != nil && (c.ptr || !isPointer(fdecl.Recv.List[0].Type))
+			}
+			for _, fld := range anonymousFields(c.typ) {
+				if t, ok := l.embeddedType(fld); ok {
+					next = append(next, candidate{t, c.ptr || isPointer(fld.Type)})
+				}
+			}
+		}
+		level = next
+	}
+
+	return false
+}
+
+// embeddedTypes returns types of all anonymous fields of struct typ,
+// or embedded interfaces of interface typ.
+func (l *loader) embeddedTypes(typ types.Type) (embedded []types.Type) {
+	for _, fld := range anonymousFields(typ) {
+		if t, ok := l.embeddedType(fld); ok {
+			embedded = append(embedded, t)
+		}
+	}
+	return
+}
+
+// anonymousFields returns anonymous fields of struct typ, or embedded
+// interfaces of interface typ.
+func anonymousFields(typ types.Type) (anonymous []*ast.Field) {
+	var fields *ast.FieldList
+	switch t := typeSpecOf(typ).(type) {
+	case *ast.StructType:
+		fields = t.Fields
+	case *ast.InterfaceType:
+		fields = t.Methods
+	}
+	if fields == nil {
+		return nil
+	}
+
+	for _, fld := range fields.List {
+		if len(fld.Names) == 0 {
+			anonymous = append(anonymous, fld)
+		}
+	}
+	return
+}
+
+// embeddedType gives the type of anonymous field fld.
+func (l *loader) embeddedType(fld *ast.Field) (types.Type, bool) {
+	t := l.typeOf(fld.Type, nil)
+	if t.Kind == ast.Bad {
+		return t, false
+	}
+	l.regainPkgName(&t, t.Node.Pos())
+	return t, true
+}
+
+// typeSpecOf returns the type expression of the declaration typ refers to.
+func typeSpecOf(typ types.Type) ast.Expr {
+	n := typ.Node
+	if star, ok := n.(*ast.StarExpr); ok {
+		n = star.X
+	}
+
+	id, ok := n.(*ast.Ident)
+	if !ok || id.Obj == nil || id.Obj.Kind != ast.Typ {
+		return nil
+	}
+
+	spec, ok := id.Obj.Decl.(*ast.TypeSpec)
+	if !ok {
+		return nil
+	}
+
+	return spec.Type
+}
+
+func isPointer(n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.StarExpr:
+		return true
+	case *ast.ParenExpr:
+		return isPointer(n.X)
+	}
+	return false
+}
+
+// embeddedName gives the field name of an anonymous field, which is the
+// unqualified type name.
+func embeddedName(e ast.Expr) string {
+	switch t := depointer(e).(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	}
+	return ""
+}
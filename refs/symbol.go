@@ -0,0 +1,169 @@
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/ast"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// findSymbol resolves qualified symbol name, like "import/path/pkg.Func",
+// "pkg.Type.Method" or "pkg.Type.Field", into the file name and offset of
+// its declaration. The package is looked up by go.mod, and in Query.GoPath
+// by import path, then in path by directory name.
+func (ctx *Context) findSymbol(sym string) (string, int, error) {
+	slash := strings.LastIndex(sym, "/") + 1
+
+	// the last element of import path may have dots, longer path first
+	dots := strings.Split(sym[slash:], ".")
+	err := errorGenerator("cannot find package of symbol %s", sym)
+	for i := len(dots) - 1; i > 0; i-- {
+		importPath := sym[:slash] + strings.Join(dots[:i], ".")
+		names := dots[i:]
+		if len(names) > 2 {
+			continue
+		}
+
+		dir := ctx.findPkgDir(importPath)
+		if dir == "" {
+			continue
+		}
+
+		// names might be in the package of shorter path
+		ident, e := ctx.findDeclIdent(dir, names)
+		if e != nil {
+			err = e
+			continue
+		}
+		position := ctx.fset.Position(ident.Pos())
+		return position.Filename, position.Offset, nil
+	}
+
+	return "", 0, err
+}
+
+func (ctx *Context) findPkgDir(importPath string) string {
+	if dir := ctx.module.dirOf(importPath); dir != "" {
+		return dir
+	}
+
+	for _, root := range ctx.gopath {
+		dir := filepath.Join(root, filepath.FromSlash(importPath))
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+
+	suffix := string(filepath.Separator) + filepath.FromSlash(importPath)
+	found := ""
+	filepath.Walk(ctx.Path, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case found != "":
+			return filepath.SkipDir
+		case err != nil || !info.IsDir():
+			return nil
+		case strings.HasSuffix(path, suffix):
+			found = path
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return found
+}
+
+// findDeclIdent finds the name in declaration of names, a top level
+// identifier, or a method or field of type, in package of dir.
+func (ctx *Context) findDeclIdent(dir string, names []string) (*ast.Ident, error) {
+	filenames, err := getFileNames(false, dir, ctx.Overlay)
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := ctx.parseFiles(ctx.filterFiles(filenames))
+	if err != nil {
+		return nil, errorGenerator("cannot parse package %s, %v", dir, err)
+	}
+
+	for _, pkg := range pkgs {
+		if strings.HasSuffix(pkg.Name, "_test") {
+			continue
+		}
+
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				if ident := declIdentOf(decl, names); ident != nil {
+					return ident, nil
+				}
+			}
+		}
+	}
+
+	return nil, errorGenerator("cannot find %s in package %s", strings.Join(names, "."), dir)
+}
+
+func declIdentOf(decl ast.Decl, names []string) *ast.Ident {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Name.Name != names[len(names)-1] {
+			return nil
+		}
+		if d.Recv == nil && len(names) == 1 ||
+			d.Recv != nil && len(names) == 2 && len(d.Recv.List) == 1 &&
+				embeddedName(d.Recv.List[0].Type) == names[0] {
+			return d.Name
+		}
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				if s.Name.Name != names[0] {
+					continue
+				}
+				if len(names) == 1 {
+					return s.Name
+				}
+				return memberIdentOf(s.Type, names[1])
+			case *ast.ValueSpec:
+				for _, ident := range s.Names {
+					if len(names) == 1 && ident.Name == names[0] {
+						return ident
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// memberIdentOf finds field of struct type, or method of interface type
+func memberIdentOf(typ ast.Expr, name string) *ast.Ident {
+	var fields *ast.FieldList
+	switch t := typ.(type) {
+	case *ast.StructType:
+		fields = t.Fields
+	case *ast.InterfaceType:
+		fields = t.Methods
+	default:
+		return nil
+	}
+
+	for _, field := range fields.List {
+		for _, ident := range field.Names {
+			if ident.Name == name {
+				return ident
+			}
+		}
+		if len(field.Names) != 0 || embeddedName(field.Type) != name {
+			continue
+		}
+
+		// embedded field is named by its type
+		switch t := depointer(field.Type).(type) {
+		case *ast.Ident:
+			return t
+		case *ast.SelectorExpr:
+			return t.Sel
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/ast"
+	"path/filepath"
+	"strings"
+)
+
+func isTestFile(filename string) bool {
+	return strings.HasSuffix(filename, "_test.go")
+}
+
+// registerTestedImports makes the external test packages in pkgs import the
+// package under test, the one in the same directory named without "_test",
+// with its _test.go files. Other packages import it without tests.
+func (ctx *Context) registerTestedImports(pkgs map[string]*ast.Package) {
+	tested := make(map[*ast.Package]testedImport)
+	for key, pkg := range pkgs {
+		if !strings.HasSuffix(pkg.Name, "_test") {
+			continue
+		}
+
+		dir := filepath.Dir(key)
+		under, ok := pkgs[filepath.Join(dir, strings.TrimSuffix(pkg.Name, "_test"))]
+		if !ok {
+			continue
+		}
+		if path := ctx.dirImportPath(dir); path != "" {
+			tested[pkg] = testedImport{path, under}
+		}
+	}
+
+	ctx.mutex.Lock()
+	defer ctx.mutex.Unlock()
+	for pkg, t := range tested {
+		ctx.tested[pkg] = t
+	}
+}
@@ -0,0 +1,69 @@
+package refs
+
+import (
+	"code.google.com/p/rog-go/exp/go/ast"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gopathRoot gives the longest root in l.gopath which dir is in, empty if
+// there isn't one.
+func (l *loader) gopathRoot(dir string) string {
+	root := ""
+	for _, p := range l.gopath {
+		p = strings.TrimSuffix(p, string(filepath.Separator))
+		if (dir == p || strings.HasPrefix(dir, p+string(filepath.Separator))) && len(p) > len(root) {
+			root = p
+		}
+	}
+
+	return root
+}
+
+// vendorImportPath gives the import path of the vendored copy of package
+// importPath, seen from dir, like "a/vendor/x/y". The vendor directories of
+// dir and its parents are looked up in turn, up to the root of GOPATH.
+// importPath is given if there isn't a vendored copy.
+func (l *loader) vendorImportPath(dir, importPath string) string {
+	root := l.gopathRoot(dir)
+	if root == "" {
+		return importPath
+	}
+
+	for d := dir; ; d = filepath.Dir(d) {
+		vendored := filepath.Join(d, "vendor", filepath.FromSlash(importPath))
+		if info, err := os.Stat(vendored); err == nil && info.IsDir() {
+			if rel, err := filepath.Rel(root, vendored); err == nil {
+				return filepath.ToSlash(rel)
+			}
+		}
+
+		if d == root || !strings.HasPrefix(d, root) {
+			break
+		}
+	}
+
+	return importPath
+}
+
+// vendorImports makes imports of file f, in directory dir, refer to the
+// vendored copies of packages, if any, so they're imported and named the
+// way files of vendored copies are.
+func (l *loader) vendorImports(f *ast.File, dir string) {
+	if f == nil {
+		return
+	}
+
+	for _, spec := range importsOf(f) {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		if vendored := l.vendorImportPath(dir, path); vendored != path {
+			spec.Path.Value = strconv.Quote(vendored)
+		}
+	}
+}
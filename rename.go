@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/zhouhua015/goref/refs"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// lines of context around changed lines in diff
+const diffContext = 3
+
+// rename renames the identifier given by query to name, changed files are
+// written, or printed as unified diff if diff is true.
+func rename(query refs.Query, name string, diff bool) {
+	renamed, err := refs.Rename(context.Background(), query, name)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = ""
+	}
+
+	var filenames []string
+	for filename := range renamed {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	for _, filename := range filenames {
+		src, ok := query.Overlay[filename]
+		if !ok {
+			if src, err = ioutil.ReadFile(filename); err != nil {
+				fail("cannot read %s, %v", filename, err)
+			}
+		}
+
+		if diff {
+			printDiff(processFilePath(filename, wd), src, renamed[filename])
+			continue
+		}
+
+		info, err := os.Stat(filename)
+		if err != nil {
+			fail("cannot stat %s, %v", filename, err)
+		}
+		if err = ioutil.WriteFile(filename, renamed[filename], info.Mode()); err != nil {
+			fail("cannot write %s, %v", filename, err)
+		}
+	}
+}
+
+// printDiff prints the unified diff of old and new contents of file name.
+// Renaming never adds or removes lines, so lines of both are paired one to
+// one.
+func printDiff(name string, old, new []byte) {
+	oldLines := strings.SplitAfter(string(old), "\n")
+	newLines := strings.SplitAfter(string(new), "\n")
+	if len(oldLines) != len(newLines) {
+		fail("cannot diff %s, numbers of lines differ", name)
+	}
+
+	var changed []int
+	for i := range oldLines {
+		if oldLines[i] != newLines[i] {
+			changed = append(changed, i)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	fmt.Printf("--- a/%s\n+++ b/%s\n", name, name)
+	for i := 0; i < len(changed); {
+		// hunk covers changed lines close enough to share context
+		j := i
+		for j+1 < len(changed) && changed[j+1]-changed[j] <= 2*diffContext {
+			j++
+		}
+
+		start := changed[i] - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := changed[j] + diffContext + 1
+		if end > len(oldLines) {
+			end = len(oldLines)
+		}
+		if end > start && oldLines[end-1] == "" {
+			// after the last newline
+			end--
+		}
+
+		fmt.Printf("@@ -%d,%d +%d,%d @@\n", start+1, end-start, start+1, end-start)
+		for k := start; k < end; k++ {
+			if oldLines[k] == newLines[k] {
+				printDiffLine(" ", oldLines[k])
+				continue
+			}
+			printDiffLine("-", oldLines[k])
+			printDiffLine("+", newLines[k])
+		}
+		i = j + 1
+	}
+}
+
+func printDiffLine(prefix, line string) {
+	if strings.HasSuffix(line, "\n") {
+		fmt.Print(prefix + line)
+		return
+	}
+	fmt.Print(prefix + line + "\n\\ No newline at end of file\n")
+}
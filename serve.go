@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/zhouhua015/goref/refs"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// daemonRequest is a query sent to the daemon, as JSON. File names must be
+// absolute, the daemon doesn't share the working directory with clients.
+type daemonRequest struct {
+	FileName   string
+	Offset     int
+	Symbol     string
+	Path       string
+	Recurse    bool
+	Scope      string
+	Mode       string
+	Dispatch   bool
+	Jobs       int
+	Index      string
+	Kinds      []string
+	GOOS       string
+	GOARCH     string
+	Tags       []string
+	AllConfigs bool
+	SkipTests  bool
+	Importing  bool
+	Overlay    map[string][]byte
+}
+
+// daemonResponse is the answer of daemon, as JSON. Error is not empty if
+// the query fails.
+type daemonResponse struct {
+	Result *refs.Result
+	Error  string
+}
+
+// defaultSocket gives the Unix domain socket the daemon listens on,
+// $XDG_RUNTIME_DIR/goref.sock, or goref.sock in the private directory
+// goref-UID of temporary directory. Others can't bind or connect to it.
+func defaultSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "goref.sock")
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("goref-%d", os.Getuid()), "goref.sock")
+}
+
+// serve answers queries on socket until it's interrupted. The directory of
+// socket is made, accessible only by the current user, if it's not there.
+func serve(socket string, logger *log.Logger) {
+	dir := filepath.Dir(socket)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		fail("cannot make directory of socket, %v", err)
+	}
+	for _, name := range []string{dir, socket} {
+		if err := checkOwner(name); err != nil {
+			fail("cannot serve on %s, %v", socket, err)
+		}
+	}
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		fail("daemon is running already on %s", socket)
+	}
+	// nobody is listening, it's left by a dead daemon
+	os.Remove(socket)
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		fail("cannot listen on %s, %v", socket, err)
+	}
+	if err = os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		fail("cannot make socket private, %v", err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+
+	serveOn(l, logger)
+}
+
+// serveOn answers queries accepted by l until it's closed. Imported packages
+// are kept in memory until their files are changed, so mostly only files in
+// search path are parsed for each query.
+func serveOn(l net.Listener, logger *log.Logger) {
+	// imported packages are shared by queries, they're answered one by one
+	cache := refs.NewCache()
+	var mu sync.Mutex
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			// closed by signal, socket file is removed by Close
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			var req daemonRequest
+			if err := json.NewDecoder(conn).Decode(&req); err != nil {
+				json.NewEncoder(conn).Encode(&daemonResponse{Error: fmt.Sprintf("invalid request, %v", err)})
+				return
+			}
+
+			mu.Lock()
+			result, err := refs.Find(context.Background(), refs.Query{
+				FileName:   req.FileName,
+				Offset:     req.Offset,
+				Symbol:     req.Symbol,
+				Path:       req.Path,
+				Recurse:    req.Recurse,
+				Scope:      req.Scope,
+				Mode:       req.Mode,
+				Dispatch:   req.Dispatch,
+				Jobs:       req.Jobs,
+				Index:      req.Index,
+				Kinds:      req.Kinds,
+				GOOS:       req.GOOS,
+				GOARCH:     req.GOARCH,
+				Tags:       req.Tags,
+				AllConfigs: req.AllConfigs,
+				SkipTests:  req.SkipTests,
+				Importing:  req.Importing,
+				Overlay:    req.Overlay,
+				Cache:      cache,
+				Logger:     logger,
+			})
+			mu.Unlock()
+
+			resp := &daemonResponse{Result: result}
+			if err != nil {
+				resp.Error = err.Error()
+			}
+			json.NewEncoder(conn).Encode(resp)
+		}()
+	}
+}
+
+// askDaemon sends query to the daemon listening on socket. ok is false if
+// there isn't a running daemon, then the query should be answered locally.
+func askDaemon(socket string, query refs.Query) (result *refs.Result, ok bool, err error) {
+	// queries carry unsaved files, they're never sent to others' daemons
+	for _, name := range []string{filepath.Dir(socket), socket} {
+		if err = checkOwner(name); err != nil {
+			return nil, true, fmt.Errorf("refuse to ask daemon, %v", err)
+		}
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, false, nil
+	}
+	defer conn.Close()
+
+	req := &daemonRequest{
+		Offset:     query.Offset,
+		Symbol:     query.Symbol,
+		Recurse:    query.Recurse,
+		Scope:      query.Scope,
+		Mode:       query.Mode,
+		Dispatch:   query.Dispatch,
+		Jobs:       query.Jobs,
+		Index:      query.Index,
+		Kinds:      query.Kinds,
+		GOOS:       query.GOOS,
+		GOARCH:     query.GOARCH,
+		Tags:       query.Tags,
+		AllConfigs: query.AllConfigs,
+		SkipTests:  query.SkipTests,
+		Importing:  query.Importing,
+		Overlay:    query.Overlay,
+	}
+	// FileName is empty if the identifier is given by Symbol
+	if query.FileName != "" {
+		if req.FileName, err = filepath.Abs(query.FileName); err != nil {
+			return nil, true, err
+		}
+	}
+	if query.Path != "" {
+		if req.Path, err = filepath.Abs(query.Path); err != nil {
+			return nil, true, err
+		}
+	}
+
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, true, fmt.Errorf("cannot send query to daemon, %v", err)
+	}
+
+	var resp daemonResponse
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, true, fmt.Errorf("cannot read answer of daemon, %v", err)
+	}
+	if resp.Error != "" {
+		return nil, true, fmt.Errorf("%s", resp.Error)
+	}
+
+	return resp.Result, true, nil
+}
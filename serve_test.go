@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/zhouhua015/goref/refs"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestServe asks the daemon twice for the same function, which is declared
+// by an imported package. The package is changed on disk between the
+// queries, so it has to be parsed again.
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goref-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"go.mod": "module example.com/serve\n",
+		"a/a.go": "package a\n\nfunc F() {}\n",
+		"b/b.go": "package b\n\nimport \"example.com/serve/a\"\n\nfunc G() {\n\ta.F()\n}\n",
+	}
+	for name, src := range files {
+		write(t, filepath.Join(dir, name), src)
+	}
+
+	socket := filepath.Join(dir, "goref.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveOn(l, nil)
+
+	query := refs.Query{
+		FileName: filepath.Join(dir, "b", "b.go"),
+		Offset:   strings.Index(files["b/b.go"], "F()"),
+		Path:     filepath.Join(dir, "b"),
+	}
+	ask := func(line int) {
+		result, ok, err := askDaemon(socket, query)
+		if !ok || err != nil {
+			t.Fatalf("no answer from daemon, %v", err)
+		}
+
+		decl := result.DeclPos
+		if decl.Filename != filepath.Join(dir, "a", "a.go") || decl.Line != line || decl.Column != 6 {
+			t.Errorf("declaration at %v, want a/a.go:%d:6", decl, line)
+		}
+		if len(result.References) != 1 || result.References[0].Start.Line != 6 {
+			t.Errorf("references %v, want the one at b/b.go:6", result.References)
+		}
+	}
+
+	ask(3)
+
+	// file times might be too coarse to tell the change
+	a := filepath.Join(dir, "a", "a.go")
+	write(t, a, "package a\n\n// F is moved\nfunc F() {}\n")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(a, later, later); err != nil {
+		t.Fatal(err)
+	}
+	ask(4)
+
+	// the identifier is given by symbol, without file name
+	query = refs.Query{Symbol: "example.com/serve/a.F", Path: filepath.Join(dir, "b")}
+	ask(4)
+}
+
+func write(t *testing.T, filename, src string) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+// checkOwner can't tell the owner of file, every one is trusted.
+func checkOwner(filename string) error {
+	return nil
+}
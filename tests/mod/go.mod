@@ -0,0 +1,3 @@
+module example.com/modtest
+
+go 1.16
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"fmt"
+
+	"example.com/modtest/greet"
+)
+
+func main() {
+	fmt.Println(greet.Hello("world"))
+}
@@ -0,0 +1,9 @@
+package lib
+
+type v1 struct {
+	Open bool
+}
+
+func isOpen(v v1) bool {
+	return v.Open
+}
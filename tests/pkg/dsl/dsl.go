@@ -0,0 +1,13 @@
+package dsl
+
+type Assertion struct {
+	actual interface{}
+}
+
+func Expect(actual interface{}) *Assertion {
+	return &Assertion{actual}
+}
+
+func (a *Assertion) To(expected interface{}) bool {
+	return a.actual == expected
+}
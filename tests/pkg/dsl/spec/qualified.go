@@ -0,0 +1,7 @@
+package spec
+
+import "github.com/zhouhua015/goref/tests/pkg/dsl"
+
+func CheckQualified() bool {
+	return dsl.Expect(2).To(2)
+}
@@ -0,0 +1,9 @@
+package spec
+
+import (
+	. "github.com/zhouhua015/goref/tests/pkg/dsl"
+)
+
+func Check() bool {
+	return Expect(1).To(1)
+}
@@ -0,0 +1,10 @@
+package kinds
+
+func Counter() int {
+	n := 0
+	n = 1
+	n++
+	p := &n
+	*p += n
+	return n
+}
@@ -0,0 +1,28 @@
+package label
+
+func find(grid [][]int, n int) (int, int) {
+outer:
+	for i, row := range grid {
+		for j, v := range row {
+			if v < 0 {
+				continue outer
+			}
+			if v == n {
+				return i, j
+			}
+			if v > n {
+				break outer
+			}
+		}
+	}
+
+	retry := func() {
+	outer:
+		for {
+			break outer
+		}
+	}
+	retry()
+
+	return -1, -1
+}
@@ -0,0 +1,5 @@
+package lastline
+
+func Last() int { return 1 }
+
+var last = Last()
\ No newline at end of file
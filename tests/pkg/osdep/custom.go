@@ -0,0 +1,8 @@
+//go:build custom
+
+package osdep
+
+// Custom greets only with tag custom
+func Custom() string {
+	return "custom " + Name()
+}
@@ -0,0 +1,13 @@
+//go:build ignore
+
+package main
+
+import "github.com/zhouhua015/goref/tests/pkg/osdep"
+
+func Name() string {
+	return "generator"
+}
+
+func main() {
+	println(osdep.Greeting(), Name())
+}
@@ -0,0 +1,8 @@
+// +build !windows
+
+package osdep
+
+// Legacy greets everywhere but windows, by the old constraint syntax
+func Legacy() string {
+	return "legacy " + Name()
+}
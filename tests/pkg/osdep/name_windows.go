@@ -0,0 +1,5 @@
+package osdep
+
+func Name() string {
+	return "windows"
+}
@@ -0,0 +1,6 @@
+package osdep
+
+// Greeting greets with the name of OS
+func Greeting() string {
+	return "hello, " + Name()
+}
@@ -0,0 +1,16 @@
+package pkgname
+
+import (
+	"fmt"
+	_ "image/png"
+	str "strings"
+)
+
+func Upper(s string) string {
+	fmt.Println(s)
+	return str.ToUpper(s)
+}
+
+func Quote(str string) string {
+	return fmt.Sprintf("%q", str)
+}
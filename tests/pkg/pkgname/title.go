@@ -0,0 +1,7 @@
+package pkgname
+
+import "strings"
+
+func Title(s string) string {
+	return strings.Title(s)
+}
@@ -0,0 +1,6 @@
+package prune
+
+// Hay doesn't have the name searched
+func Hay() int {
+	return 0
+}
@@ -0,0 +1,6 @@
+package other
+
+// Needle of other package, which doesn't import prune
+func Needle() int {
+	return 2
+}
@@ -0,0 +1,5 @@
+package plain
+
+func Hay() int {
+	return 0
+}
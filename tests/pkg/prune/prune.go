@@ -0,0 +1,6 @@
+package prune
+
+// Needle is searched by prefilter tests
+func Needle() int {
+	return 1
+}
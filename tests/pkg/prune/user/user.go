@@ -0,0 +1,7 @@
+package user
+
+import "github.com/zhouhua015/goref/tests/pkg/prune"
+
+func Use() int {
+	return prune.Needle()
+}
@@ -0,0 +1,34 @@
+package recv
+
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Inc() {
+	c.n++
+}
+
+func (c Counter) Get() int {
+	return c.n
+}
+
+type Getter interface {
+	Get() int
+}
+
+type Incer interface {
+	Inc()
+}
+
+func Use() int {
+	c := Counter{}
+	c.Inc()
+	inc := c.Inc
+	inc()
+	(*Counter).Inc(&c)
+
+	get := Counter.Get
+	pget := (*Counter).Get
+	var g Getter = c
+	return get(c) + pget(&c) + g.Get()
+}
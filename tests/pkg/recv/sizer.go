@@ -0,0 +1,49 @@
+package recv
+
+type Sizer interface {
+	Size() int
+}
+
+type Value struct{}
+
+func (v Value) Size() int { return 0 }
+
+// only *Pointer satisfies Sizer
+type Pointer struct{}
+
+func (p *Pointer) Size() int { return 1 }
+
+// only *Embedded satisfies Sizer, Size is promoted through addressable
+// Pointer
+type Embedded struct {
+	Pointer
+}
+
+func Sizes(v Value, p *Pointer, e Embedded) int {
+	var s Sizer = v
+	n := s.Size()
+	s = p
+	n += s.Size()
+	s = &e
+	return n + s.Size() + e.Size()
+}
+
+// Wrong doesn't satisfy Sizer, its Size gives another type
+type Wrong struct{}
+
+func (w Wrong) Size() int64 { return 2 }
+
+type Resizer interface {
+	Resize(to *Value, by ...int) (Sizer, error)
+}
+
+func (v Value) Resize(to *Value, by ...int) (Sizer, error) { return to, nil }
+
+// Resize of *Pointer takes another type, neither *Pointer nor *Embedded
+// satisfies Resizer
+func (p *Pointer) Resize(to *Embedded, by ...int) (Sizer, error) { return to, nil }
+
+// EmbeddedPtr satisfies Sizer, Size is promoted through embedded *Pointer
+type EmbeddedPtr struct {
+	*Pointer
+}
@@ -0,0 +1,14 @@
+package rename
+
+// Count is referred by the external test
+func Count(items []string) int {
+	return len(items)
+}
+
+func double(n int) int {
+	return n * 2
+}
+
+func triple(n int) int {
+	return double(n) + n
+}
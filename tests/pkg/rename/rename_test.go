@@ -0,0 +1,13 @@
+package rename_test
+
+import (
+	"testing"
+
+	"github.com/zhouhua015/goref/tests/pkg/rename"
+)
+
+func TestCount(t *testing.T) {
+	if rename.Count([]string{"a"}) != 1 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,12 @@
+package tested_test
+
+import (
+	"fmt"
+
+	"github.com/zhouhua015/goref/tests/pkg/tested"
+)
+
+func ExampleCounter() {
+	fmt.Println(tested.Counter(2).Triple())
+	// Output: 6
+}
@@ -0,0 +1,12 @@
+package tested_test
+
+import (
+	"fmt"
+
+	"github.com/zhouhua015/goref/tests/pkg/tested"
+)
+
+func ExampleDouble() {
+	fmt.Println(tested.Double(3))
+	// Output: 6
+}
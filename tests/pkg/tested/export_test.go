@@ -0,0 +1,6 @@
+package tested
+
+// Triple is a method for tests only
+func (c Counter) Triple() int {
+	return int(c) * 3
+}
@@ -0,0 +1,9 @@
+package tested
+
+// Double doubles n
+func Double(n int) int {
+	return n * 2
+}
+
+// Counter counts
+type Counter int
@@ -0,0 +1,18 @@
+package user
+
+import "github.com/zhouhua015/goref/tests/pkg/tested"
+
+// wrapped has its own Triple, the one of tested.Counter is declared by a
+// _test.go file, which isn't seen here
+type wrapped struct {
+	tested.Counter
+}
+
+func (w wrapped) Triple() int {
+	return int(w.Counter) * 3
+}
+
+func Use() int {
+	var w wrapped
+	return w.Triple()
+}
@@ -0,0 +1,20 @@
+package tswitch
+
+import "fmt"
+
+func Describe(x interface{}) string {
+	switch v := x.(type) {
+	case int:
+		return fmt.Sprintf("int %d", v)
+	case string:
+		return "string " + v
+	case fmt.Stringer:
+		if v == nil {
+			return ""
+		}
+		return v.String()
+	}
+
+	v := fmt.Sprint("shadowed")
+	return fmt.Sprint(v)
+}
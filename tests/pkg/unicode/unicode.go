@@ -0,0 +1,6 @@
+package unicode
+
+func Greet() string {
+	héllo, 𝔀orld := "é", "𝔀"
+	return 𝔀orld + héllo
+}
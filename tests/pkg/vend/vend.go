@@ -0,0 +1,8 @@
+package vend
+
+import "example.com/quote"
+
+// Proverb gives the vendored quote
+func Proverb() string {
+	return quote.Quote()
+}
@@ -0,0 +1,8 @@
+package app
+
+import "github.com/zhouhua015/goref/tests/pkg/vendmix/quote"
+
+// App uses the vendored quote
+func App() string {
+	return quote.Quote()
+}
@@ -0,0 +1,6 @@
+package quote
+
+// Quote is the copy in GOPATH
+func Quote() string {
+	return "gopath"
+}
@@ -0,0 +1,8 @@
+package user
+
+import "github.com/zhouhua015/goref/tests/pkg/vendmix/quote"
+
+// User uses the quote in GOPATH
+func User() string {
+	return quote.Quote()
+}
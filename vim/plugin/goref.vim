@@ -3,21 +3,20 @@ if !exists("g:goref_command")
 endif
 
 function! GorefUnderCursor()
-    let pos = getpos(".")[1:2]
+    let [line, col] = getpos(".")[1:2]
     if &encoding == 'utf-8'
-        let offs = line2byte(pos[0]) + pos[1] - 2
+        let unit = "bytes"
     else
-        let c = pos[1]
-        let buf = line('.') == 1 ? "" : (join(getline(1, pos[0] - 1), "\n") . "\n")
-        let buf .= c == 1 ? "" : getline(pos[0])[:c-2]
-        let offs = len(iconv(buf, &encoding, "utf-8"))
+        let unit = "runes"
+        let col = strchars(col == 1 ? "" : getline(line)[:col-2]) + 1
     endif
-    silent call Goref("-o=" . offs)
+    silent call Goref("-unit=" . unit, "-pos=" . bufname('%') . ":" . line . ":" . col)
 endfunction
 
-function! Goref(arg)
+function! Goref(...)
     let bufname = bufname('%')
-    let references=system(g:goref_command . " -v -R -f=" . bufname . " " . shellescape(a:arg) . " " . getcwd())
+    let args = join(map(copy(a:000), 'shellescape(v:val)'), " ")
+    let references=system(g:goref_command . " -v -R -f=" . bufname . " " . args . " " . getcwd())
 
     let old_efm = &efm
     let &efm="%I%f:%l:%c,%C,%Z%m"